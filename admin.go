@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminConfig enables the admin API on Addr, every request has to carry "Authorization: Bearer <Token>".
+// The API is disabled if the token is empty.
+type AdminConfig struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+// Commands executed by startStrategy between the events
+const (
+	adminResume       = "resume"
+	adminFlatten      = "flatten"
+	adminCancelOrders = "cancel_orders"
+	adminState        = "state"
+	// adminSync does nothing, its reply means the strategy has processed every action sent before it (used by backtests)
+	adminSync = "sync"
+)
+
+// adminDecisionsLimit is the number of the last strategy decisions returned by /admin/state
+const adminDecisionsLimit = 50
+
+type adminCommand struct {
+	name  string
+	reply chan adminReply
+}
+
+type adminReply struct {
+	Message string         `json:"message,omitempty"`
+	State   *StrategyState `json:"state,omitempty"`
+	Orders  []adminOrder   `json:"pending_orders,omitempty"`
+	Err     error          `json:"-"`
+}
+
+type adminOrder struct {
+	OrderID       string  `json:"order_id"`
+	InstrumentID  string  `json:"instrument_id"`
+	Direction     string  `json:"direction"`
+	LotsRequested int64   `json:"lots_requested"`
+	LotsExecuted  int64   `json:"lots_executed"`
+	Price         float64 `json:"price"`
+}
+
+// adminDecision is what the strategy did with one predicted action
+type adminDecision struct {
+	Time    time.Time `json:"time"`
+	Action  int       `json:"action"`
+	Outcome string    `json:"outcome"`
+}
+
+// Admin is shared by the admin server, the main loop and the strategy goroutine.
+// Pause and the predictor switch are flags read by the loop, other commands are sent to startStrategy through commands.
+type Admin struct {
+	mu         sync.Mutex
+	paused     bool
+	predictor  string
+	predictors map[string]Predictor
+	decisions  []adminDecision
+	commands   chan adminCommand
+}
+
+func newAdmin(predictors map[string]Predictor) *Admin {
+	return &Admin{predictor: "default", predictors: predictors, commands: make(chan adminCommand)}
+}
+
+// isPaused is checked by the strategy before opening a position
+func (a *Admin) isPaused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
+
+func (a *Admin) setPaused(paused bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.paused = paused
+}
+
+// activePredictor returns the predictor the main loop has to call
+func (a *Admin) activePredictor() Predictor {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.predictors[a.predictor]
+}
+
+func (a *Admin) switchPredictor(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.predictors[name]; !ok {
+		return fmt.Errorf("unknown predictor %q", name)
+	}
+	a.predictor = name
+	return nil
+}
+
+// decision remembers what the strategy did with the action, only the last adminDecisionsLimit decisions are kept
+func (a *Admin) decision(action int, outcome string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.decisions = append(a.decisions, adminDecision{Time: time.Now(), Action: action, Outcome: outcome})
+	if len(a.decisions) > adminDecisionsLimit {
+		a.decisions = a.decisions[len(a.decisions)-adminDecisionsLimit:]
+	}
+}
+
+var errStrategyNotRunning = errors.New("strategy didn't take the command: the exchange is closed or an order is being executed")
+
+// send passes the command to startStrategy and waits for the reply. The strategy takes commands between the events,
+// so an order being executed is finished first.
+func (a *Admin) send(name string, timeout time.Duration) adminReply {
+	command := adminCommand{name: name, reply: make(chan adminReply, 1)}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case a.commands <- command:
+	case <-timer.C:
+		return adminReply{Err: errStrategyNotRunning}
+	}
+	select {
+	case reply := <-command.reply:
+		return reply
+	case <-timer.C:
+		return adminReply{Err: errors.New("strategy didn't finish the command in time, see the logs")}
+	}
+}
+
+func (a *Admin) authorized(r *http.Request, token string) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAdminReply(w http.ResponseWriter, reply adminReply) {
+	if reply.Err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(reply.Err, errStrategyNotRunning) {
+			status = http.StatusConflict
+		}
+		writeAdminJSON(w, status, map[string]string{"error": reply.Err.Error()})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, reply)
+}
+
+// handler wraps the admin operation with the method and token checks
+func (a *Admin) handler(token string, method string, operation func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r, token) {
+			writeAdminJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if r.Method != method {
+			writeAdminJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use " + method})
+			return
+		}
+		operation(w, r)
+	}
+}
+
+// startAdminServer serves the admin API in the background, nil is returned if the API is disabled
+func startAdminServer(config AdminConfig, admin *Admin, logger investgo.Logger) *http.Server {
+	if config.Token == "" {
+		logger.Infof("Admin API is disabled, admin.token is not set")
+		return nil
+	}
+	// long commands (flatten) are awaited this long
+	const commandTimeout = 2 * time.Minute
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/pause", admin.handler(config.Token, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		admin.setPaused(true)
+		logger.Infof("Admin: new entries are paused")
+		writeAdminJSON(w, http.StatusOK, adminReply{Message: "paused"})
+	}))
+	mux.HandleFunc("/admin/resume", admin.handler(config.Token, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		admin.setPaused(false)
+		logger.Infof("Admin: trading is resumed")
+		// the halt after reconciliation is lifted too, it is only possible while the strategy is running
+		reply := admin.send(adminResume, 5*time.Second)
+		if reply.Err != nil && !errors.Is(reply.Err, errStrategyNotRunning) {
+			writeAdminReply(w, reply)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, adminReply{Message: "resumed"})
+	}))
+	mux.HandleFunc("/admin/flatten", admin.handler(config.Token, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		logger.Infof("Admin: flatten all positions")
+		writeAdminReply(w, admin.send(adminFlatten, commandTimeout))
+	}))
+	mux.HandleFunc("/admin/cancel-orders", admin.handler(config.Token, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		logger.Infof("Admin: cancel open orders")
+		writeAdminReply(w, admin.send(adminCancelOrders, commandTimeout))
+	}))
+	mux.HandleFunc("/admin/predictor", admin.handler(config.Token, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		err := admin.switchPredictor(name)
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		logger.Infof("Admin: switched predictor to %v", name)
+		writeAdminJSON(w, http.StatusOK, adminReply{Message: "predictor " + name})
+	}))
+	mux.HandleFunc("/admin/state", admin.handler(config.Token, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		admin.mu.Lock()
+		names := make([]string, 0, len(admin.predictors))
+		for name := range admin.predictors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		body := map[string]interface{}{
+			"paused":     admin.paused,
+			"predictor":  admin.predictor,
+			"predictors": names,
+			"decisions":  append([]adminDecision(nil), admin.decisions...),
+		}
+		admin.mu.Unlock()
+		reply := admin.send(adminState, 5*time.Second)
+		if reply.Err != nil {
+			body["error"] = reply.Err.Error()
+		} else {
+			body["state"] = reply.State
+			body["pending_orders"] = reply.Orders
+		}
+		writeAdminJSON(w, http.StatusOK, body)
+	}))
+
+	server := &http.Server{Addr: config.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.Infof("Admin API is listening on %v", config.Addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Admin API error: %v", err.Error())
+		}
+	}()
+	return server
+}
+
+// pendingOrders returns the open orders of the account
+func pendingOrders(ordersService *investgo.OrdersServiceClient, accId string, logger investgo.Logger) ([]adminOrder, error) {
+	logger.Infof("Sent GetOrders request")
+	ordersResp, err := ordersService.GetOrders(accId)
+	logger.Infof("Got response for GetOrders request")
+	if err != nil {
+		logger.Errorf("Can't get open orders: %v", err.Error())
+		return nil, err
+	}
+	var orders []adminOrder
+	for _, order := range ordersResp.GetOrders() {
+		orders = append(orders, adminOrder{
+			OrderID:       order.GetOrderId(),
+			InstrumentID:  order.GetInstrumentUid(),
+			Direction:     journalDirection(order.GetDirection()),
+			LotsRequested: order.GetLotsRequested(),
+			LotsExecuted:  order.GetLotsExecuted(),
+			Price:         order.GetInitialSecurityPrice().ToFloat(),
+		})
+	}
+	return orders, nil
+}
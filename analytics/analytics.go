@@ -0,0 +1,342 @@
+// Package analytics calculates performance metrics of the trading bot from its fills and equity curve.
+// It knows nothing about the exchange or the journal format, the caller converts its records to Fill and EquityPoint.
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type Side int
+
+const (
+	Buy Side = iota + 1
+	Sell
+)
+
+// Fill is one executed order. Amount is the money paid (Buy) or received (Sell) for all lots without commission.
+type Fill struct {
+	Time       time.Time
+	Side       Side
+	Lots       int64
+	Amount     float64
+	Commission float64
+}
+
+// Trade is a closed long position: lots bought at EntryAmount and sold at ExitAmount, Fees are the commissions of both sides
+type Trade struct {
+	EntryTime   time.Time
+	ExitTime    time.Time
+	Lots        int64
+	EntryAmount float64
+	ExitAmount  float64
+	Fees        float64
+}
+
+// NetPnL is the profit of the trade after fees
+func (t Trade) NetPnL() float64 {
+	return t.ExitAmount - t.EntryAmount - t.Fees
+}
+
+// Return is the net profit relative to the money put into the trade
+func (t Trade) Return() float64 {
+	if t.EntryAmount == 0 {
+		return 0
+	}
+	return t.NetPnL() / t.EntryAmount
+}
+
+func (t Trade) Holding() time.Duration {
+	return t.ExitTime.Sub(t.EntryTime)
+}
+
+type EquityPoint struct {
+	Time   time.Time
+	Equity float64
+}
+
+// Summary contains all metrics of the period, ratios are fractions (0.5 = 50%)
+type Summary struct {
+	Trades             int
+	NetPnL             float64
+	Fees               float64
+	WinRate            float64
+	ProfitFactor       float64
+	Expectancy         float64
+	MaxDrawdown        float64
+	MaxDrawdownPercent float64
+	Sharpe             float64
+	Sortino            float64
+	Exposure           float64
+	AverageHolding     time.Duration
+}
+
+type openLot struct {
+	time       time.Time
+	lots       int64
+	amount     float64
+	commission float64
+}
+
+// Trades matches sells with the earlier buys (FIFO) and returns the closed trades.
+// A sell matched with several buys gives one trade per buy, sells without open buys (positions left from another day) are skipped.
+func Trades(fills []Fill) []Trade {
+	fills = append([]Fill(nil), fills...)
+	sort.SliceStable(fills, func(i, j int) bool {
+		return fills[i].Time.Before(fills[j].Time)
+	})
+
+	var (
+		open   []openLot
+		trades []Trade
+	)
+	for _, fill := range fills {
+		if fill.Lots <= 0 {
+			continue
+		}
+		if fill.Side == Buy {
+			open = append(open, openLot{time: fill.Time, lots: fill.Lots, amount: fill.Amount, commission: fill.Commission})
+			continue
+		}
+		rest := fill.Lots
+		for rest > 0 && len(open) > 0 {
+			lot := &open[0]
+			matched := lot.lots
+			if rest < matched {
+				matched = rest
+			}
+			entryShare := float64(matched) / float64(lot.lots)
+			exitShare := float64(matched) / float64(fill.Lots)
+			trade := Trade{
+				EntryTime:   lot.time,
+				ExitTime:    fill.Time,
+				Lots:        matched,
+				EntryAmount: lot.amount * entryShare,
+				ExitAmount:  fill.Amount * exitShare,
+				Fees:        lot.commission*entryShare + fill.Commission*exitShare,
+			}
+			trades = append(trades, trade)
+
+			lot.amount -= trade.EntryAmount
+			lot.commission -= lot.commission * entryShare
+			lot.lots -= matched
+			if lot.lots == 0 {
+				open = open[1:]
+			}
+			rest -= matched
+		}
+	}
+	return trades
+}
+
+// WinRate is the share of trades with positive net profit
+func WinRate(trades []Trade) float64 {
+	if len(trades) == 0 {
+		return 0
+	}
+	wins := 0
+	for _, trade := range trades {
+		if trade.NetPnL() > 0 {
+			wins++
+		}
+	}
+	return float64(wins) / float64(len(trades))
+}
+
+// ProfitFactor is the gross profit divided by the gross loss, +Inf if there were no losing trades but some profit
+func ProfitFactor(trades []Trade) float64 {
+	profit, loss := 0.0, 0.0
+	for _, trade := range trades {
+		if pnl := trade.NetPnL(); pnl > 0 {
+			profit += pnl
+		} else {
+			loss -= pnl
+		}
+	}
+	if loss == 0 {
+		if profit > 0 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	return profit / loss
+}
+
+// Expectancy is the average net profit per trade
+func Expectancy(trades []Trade) float64 {
+	if len(trades) == 0 {
+		return 0
+	}
+	return NetPnL(trades) / float64(len(trades))
+}
+
+func NetPnL(trades []Trade) float64 {
+	total := 0.0
+	for _, trade := range trades {
+		total += trade.NetPnL()
+	}
+	return total
+}
+
+func Fees(trades []Trade) float64 {
+	total := 0.0
+	for _, trade := range trades {
+		total += trade.Fees
+	}
+	return total
+}
+
+// MaxDrawdown returns the biggest fall of equity from its previous peak in money and as a fraction of the peak
+func MaxDrawdown(equity []EquityPoint) (float64, float64) {
+	if len(equity) == 0 {
+		return 0, 0
+	}
+	peak := equity[0].Equity
+	maxDrawdown, maxDrawdownPercent := 0.0, 0.0
+	for _, point := range equity {
+		if point.Equity > peak {
+			peak = point.Equity
+		}
+		drawdown := peak - point.Equity
+		if drawdown > maxDrawdown {
+			maxDrawdown = drawdown
+		}
+		if peak > 0 && drawdown/peak > maxDrawdownPercent {
+			maxDrawdownPercent = drawdown / peak
+		}
+	}
+	return maxDrawdown, maxDrawdownPercent
+}
+
+// Returns converts the equity curve to simple returns between the neighbouring points
+func Returns(equity []EquityPoint) []float64 {
+	if len(equity) < 2 {
+		return nil
+	}
+	returns := make([]float64, 0, len(equity)-1)
+	for i := 1; i < len(equity); i++ {
+		if equity[i-1].Equity == 0 {
+			returns = append(returns, 0)
+			continue
+		}
+		returns = append(returns, equity[i].Equity/equity[i-1].Equity-1)
+	}
+	return returns
+}
+
+// Sharpe is the annualized Sharpe ratio of the returns with zero risk-free rate, periodsPerYear is the number of returns in a year
+func Sharpe(returns []float64, periodsPerYear float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+	mean := mean(returns)
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+	return mean / std * math.Sqrt(periodsPerYear)
+}
+
+// Sortino is like Sharpe, but only the returns below zero are counted as risk
+func Sortino(returns []float64, periodsPerYear float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+	downside := 0.0
+	for _, r := range returns {
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	downsideDeviation := math.Sqrt(downside / float64(len(returns)))
+	if downsideDeviation == 0 {
+		return 0
+	}
+	return mean(returns) / downsideDeviation * math.Sqrt(periodsPerYear)
+}
+
+// Exposure is the share of the [from, to] period when a position was open
+func Exposure(trades []Trade, from time.Time, to time.Time) float64 {
+	period := to.Sub(from)
+	if period <= 0 {
+		return 0
+	}
+	intervals := make([]Trade, len(trades))
+	copy(intervals, trades)
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].EntryTime.Before(intervals[j].EntryTime)
+	})
+	// trades of one sell overlap, so the union of the holding intervals is counted
+	var (
+		exposed    time.Duration
+		start, end time.Time
+	)
+	for i, trade := range intervals {
+		entry, exit := clamp(trade.EntryTime, from, to), clamp(trade.ExitTime, from, to)
+		if i == 0 || entry.After(end) {
+			exposed += end.Sub(start)
+			start, end = entry, exit
+			continue
+		}
+		if exit.After(end) {
+			end = exit
+		}
+	}
+	exposed += end.Sub(start)
+	return float64(exposed) / float64(period)
+}
+
+func AverageHolding(trades []Trade) time.Duration {
+	if len(trades) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, trade := range trades {
+		total += trade.Holding()
+	}
+	return total / time.Duration(len(trades))
+}
+
+// Summarize calculates all metrics, equity is expected to be sampled with the same period (for example every minute)
+func Summarize(trades []Trade, equity []EquityPoint, periodsPerYear float64) Summary {
+	summary := Summary{
+		Trades:         len(trades),
+		NetPnL:         NetPnL(trades),
+		Fees:           Fees(trades),
+		WinRate:        WinRate(trades),
+		ProfitFactor:   ProfitFactor(trades),
+		Expectancy:     Expectancy(trades),
+		AverageHolding: AverageHolding(trades),
+	}
+	summary.MaxDrawdown, summary.MaxDrawdownPercent = MaxDrawdown(equity)
+	returns := Returns(equity)
+	summary.Sharpe = Sharpe(returns, periodsPerYear)
+	summary.Sortino = Sortino(returns, periodsPerYear)
+	if len(equity) > 0 {
+		summary.Exposure = Exposure(trades, equity[0].Time, equity[len(equity)-1].Time)
+	}
+	return summary
+}
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+func clamp(t time.Time, from time.Time, to time.Time) time.Time {
+	if t.Before(from) {
+		return from
+	}
+	if t.After(to) {
+		return to
+	}
+	return t
+}
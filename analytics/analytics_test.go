@@ -0,0 +1,247 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return day.Add(time.Duration(minutes) * time.Minute)
+}
+
+func almostEqual(a float64, b float64) bool {
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return a == b
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+// tradesWithPnL returns trades of one lot with the given net profits
+func tradesWithPnL(pnls ...float64) []Trade {
+	trades := make([]Trade, len(pnls))
+	for i, pnl := range pnls {
+		trades[i] = Trade{Lots: 1, EntryAmount: 100, ExitAmount: 100 + pnl}
+	}
+	return trades
+}
+
+func equityCurve(values ...float64) []EquityPoint {
+	equity := make([]EquityPoint, len(values))
+	for i, value := range values {
+		equity[i] = EquityPoint{Time: at(i), Equity: value}
+	}
+	return equity
+}
+
+func TestTrades(t *testing.T) {
+	tests := []struct {
+		name  string
+		fills []Fill
+		want  []Trade
+	}{
+		{
+			name: "round trip",
+			fills: []Fill{
+				{Time: at(0), Side: Buy, Lots: 10, Amount: 1000, Commission: 3},
+				{Time: at(5), Side: Sell, Lots: 10, Amount: 1100, Commission: 3.3},
+			},
+			want: []Trade{{EntryTime: at(0), ExitTime: at(5), Lots: 10, EntryAmount: 1000, ExitAmount: 1100, Fees: 6.3}},
+		},
+		{
+			name: "partial closes",
+			fills: []Fill{
+				{Time: at(0), Side: Buy, Lots: 10, Amount: 1000, Commission: 10},
+				{Time: at(1), Side: Sell, Lots: 4, Amount: 480, Commission: 4},
+				{Time: at(2), Side: Sell, Lots: 6, Amount: 540, Commission: 6},
+			},
+			want: []Trade{
+				{EntryTime: at(0), ExitTime: at(1), Lots: 4, EntryAmount: 400, ExitAmount: 480, Fees: 8},
+				{EntryTime: at(0), ExitTime: at(2), Lots: 6, EntryAmount: 600, ExitAmount: 540, Fees: 12},
+			},
+		},
+		{
+			name: "sell over several buys, the rest stays open",
+			fills: []Fill{
+				{Time: at(0), Side: Buy, Lots: 5, Amount: 500, Commission: 5},
+				{Time: at(1), Side: Buy, Lots: 5, Amount: 600, Commission: 5},
+				{Time: at(2), Side: Sell, Lots: 8, Amount: 880, Commission: 8},
+			},
+			want: []Trade{
+				{EntryTime: at(0), ExitTime: at(2), Lots: 5, EntryAmount: 500, ExitAmount: 550, Fees: 10},
+				{EntryTime: at(1), ExitTime: at(2), Lots: 3, EntryAmount: 360, ExitAmount: 330, Fees: 6},
+			},
+		},
+		{
+			name: "unsorted fills",
+			fills: []Fill{
+				{Time: at(3), Side: Sell, Lots: 1, Amount: 120},
+				{Time: at(1), Side: Buy, Lots: 1, Amount: 100},
+			},
+			want: []Trade{{EntryTime: at(1), ExitTime: at(3), Lots: 1, EntryAmount: 100, ExitAmount: 120}},
+		},
+		{
+			name: "sell without a buy is skipped",
+			fills: []Fill{
+				{Time: at(0), Side: Sell, Lots: 5, Amount: 500},
+				{Time: at(1), Side: Buy, Lots: 0, Amount: 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Trades(test.fills)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v trades, want %v: %+v", len(got), len(test.want), got)
+			}
+			for i, want := range test.want {
+				trade := got[i]
+				if !trade.EntryTime.Equal(want.EntryTime) || !trade.ExitTime.Equal(want.ExitTime) || trade.Lots != want.Lots ||
+					!almostEqual(trade.EntryAmount, want.EntryAmount) || !almostEqual(trade.ExitAmount, want.ExitAmount) || !almostEqual(trade.Fees, want.Fees) {
+					t.Errorf("trade %v = %+v, want %+v", i, trade, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTradeMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		trades       []Trade
+		winRate      float64
+		profitFactor float64
+		expectancy   float64
+	}{
+		{name: "no trades"},
+		{name: "wins and losses", trades: tradesWithPnL(10, 20, -5, -10), winRate: 0.5, profitFactor: 2, expectancy: 3.75},
+		{name: "break-even is not a win", trades: tradesWithPnL(10, -5, 0), winRate: 1.0 / 3, profitFactor: 2, expectancy: 5.0 / 3},
+		{name: "no losses", trades: tradesWithPnL(10, 5), winRate: 1, profitFactor: math.Inf(1), expectancy: 7.5},
+		{name: "only losses", trades: tradesWithPnL(-10, -4), profitFactor: 0, expectancy: -7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := WinRate(test.trades); !almostEqual(got, test.winRate) {
+				t.Errorf("WinRate = %v, want %v", got, test.winRate)
+			}
+			if got := ProfitFactor(test.trades); !almostEqual(got, test.profitFactor) {
+				t.Errorf("ProfitFactor = %v, want %v", got, test.profitFactor)
+			}
+			if got := Expectancy(test.trades); !almostEqual(got, test.expectancy) {
+				t.Errorf("Expectancy = %v, want %v", got, test.expectancy)
+			}
+		})
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		equity   []EquityPoint
+		drawdown float64
+		percent  float64
+	}{
+		{name: "empty"},
+		{name: "only growth", equity: equityCurve(100, 110, 120)},
+		{name: "the biggest fall", equity: equityCurve(100, 120, 90, 130, 117), drawdown: 30, percent: 0.25},
+		{name: "money and percent of different falls", equity: equityCurve(100, 50, 1000, 800), drawdown: 200, percent: 0.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drawdown, percent := MaxDrawdown(test.equity)
+			if !almostEqual(drawdown, test.drawdown) || !almostEqual(percent, test.percent) {
+				t.Errorf("MaxDrawdown = %v, %v, want %v, %v", drawdown, percent, test.drawdown, test.percent)
+			}
+		})
+	}
+}
+
+func TestRatios(t *testing.T) {
+	tests := []struct {
+		name    string
+		returns []float64
+		sharpe  float64
+		sortino float64
+	}{
+		{name: "one return", returns: []float64{0.01}},
+		{name: "constant returns", returns: []float64{0.01, 0.01, 0.01}},
+		{name: "ups and downs", returns: []float64{0.01, -0.01, 0.02, 0}, sharpe: 6.148170459575759, sortino: 15.874507866387544},
+		// without downside there is no risk to divide by, the ratio is 0
+		{name: "no downside", returns: []float64{0.01, 0.02, 0}, sharpe: 15.874507866387544},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sharpe(test.returns, 252); !almostEqual(got, test.sharpe) {
+				t.Errorf("Sharpe = %v, want %v", got, test.sharpe)
+			}
+			if got := Sortino(test.returns, 252); !almostEqual(got, test.sortino) {
+				t.Errorf("Sortino = %v, want %v", got, test.sortino)
+			}
+		})
+	}
+}
+
+func TestReturns(t *testing.T) {
+	got := Returns(equityCurve(100, 110, 99, 0, 10))
+	want := []float64{0.1, -0.1, -1, 0}
+	if len(got) != len(want) {
+		t.Fatalf("Returns = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !almostEqual(got[i], want[i]) {
+			t.Errorf("Returns = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExposure(t *testing.T) {
+	tests := []struct {
+		name   string
+		trades []Trade
+		from   time.Time
+		to     time.Time
+		want   float64
+	}{
+		{name: "empty period", trades: []Trade{{EntryTime: at(0), ExitTime: at(10)}}, from: at(10), to: at(10)},
+		{name: "no trades", from: at(0), to: at(100)},
+		{
+			name: "overlapping trades are counted once, the trades are clamped to the period",
+			trades: []Trade{
+				{EntryTime: at(20), ExitTime: at(40)},
+				{EntryTime: at(-10), ExitTime: at(5)},
+				{EntryTime: at(10), ExitTime: at(30)},
+				{EntryTime: at(60), ExitTime: at(70)},
+			},
+			from: at(0),
+			to:   at(100),
+			want: 0.45,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Exposure(test.trades, test.from, test.to); !almostEqual(got, test.want) {
+				t.Errorf("Exposure = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAverageHolding(t *testing.T) {
+	tests := []struct {
+		name   string
+		trades []Trade
+		want   time.Duration
+	}{
+		{name: "no trades"},
+		{name: "two trades", trades: []Trade{{EntryTime: at(0), ExitTime: at(10)}, {EntryTime: at(5), ExitTime: at(25)}}, want: 15 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := AverageHolding(test.trades); got != test.want {
+				t.Errorf("AverageHolding = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package analytics
+
+import "math"
+
+// BenchmarkStats compares the equity curve with a benchmark over the same window. Return is the return of the benchmark,
+// ExcessReturn is the return of the equity minus it, Alpha is annualized, all of them are fractions.
+type BenchmarkStats struct {
+	Return       float64
+	ExcessReturn float64
+	Alpha        float64
+	Beta         float64
+	Correlation  float64
+}
+
+// AlignBenchmark samples the benchmark at the times of the equity points: the last benchmark value at or before the time,
+// the first value for the points before the benchmark starts. Nothing is returned for an empty benchmark.
+func AlignBenchmark(equity []EquityPoint, benchmark []EquityPoint) []EquityPoint {
+	if len(benchmark) == 0 {
+		return nil
+	}
+	aligned := make([]EquityPoint, 0, len(equity))
+	j := 0
+	for _, point := range equity {
+		for j+1 < len(benchmark) && !benchmark[j+1].Time.After(point.Time) {
+			j++
+		}
+		aligned = append(aligned, EquityPoint{Time: point.Time, Equity: benchmark[j].Equity})
+	}
+	return aligned
+}
+
+// CompareBenchmark calculates the statistics of the equity against the benchmark aligned by AlignBenchmark,
+// periodsPerYear annualizes the alpha of the returns between the points
+func CompareBenchmark(equity []EquityPoint, aligned []EquityPoint, periodsPerYear float64) BenchmarkStats {
+	var stats BenchmarkStats
+	if len(equity) < 2 || len(aligned) != len(equity) || equity[0].Equity == 0 || aligned[0].Equity == 0 {
+		return stats
+	}
+	stats.Return = aligned[len(aligned)-1].Equity/aligned[0].Equity - 1
+	stats.ExcessReturn = equity[len(equity)-1].Equity/equity[0].Equity - 1 - stats.Return
+
+	returns, benchmarkReturns := Returns(equity), Returns(aligned)
+	meanReturn, meanBenchmark := mean(returns), mean(benchmarkReturns)
+	covariance, variance, benchmarkVariance := 0.0, 0.0, 0.0
+	for i := range returns {
+		covariance += (returns[i] - meanReturn) * (benchmarkReturns[i] - meanBenchmark)
+		variance += (returns[i] - meanReturn) * (returns[i] - meanReturn)
+		benchmarkVariance += (benchmarkReturns[i] - meanBenchmark) * (benchmarkReturns[i] - meanBenchmark)
+	}
+	if benchmarkVariance > 0 {
+		stats.Beta = covariance / benchmarkVariance
+		if variance > 0 {
+			stats.Correlation = covariance / math.Sqrt(variance*benchmarkVariance)
+		}
+	}
+	stats.Alpha = (meanReturn - stats.Beta*meanBenchmark) * periodsPerYear
+	return stats
+}
+
+// ScaleCurve rescales the curve to start at start, so a benchmark can be drawn with the equity
+func ScaleCurve(curve []EquityPoint, start float64) []EquityPoint {
+	if len(curve) == 0 || curve[0].Equity == 0 {
+		return nil
+	}
+	scaled := make([]EquityPoint, len(curve))
+	for i, point := range curve {
+		scaled[i] = EquityPoint{Time: point.Time, Equity: start * point.Equity / curve[0].Equity}
+	}
+	return scaled
+}
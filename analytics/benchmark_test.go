@@ -0,0 +1,68 @@
+package analytics
+
+import "testing"
+
+func TestAlignBenchmark(t *testing.T) {
+	equity := equityCurve(1, 2, 3, 4)
+	benchmark := []EquityPoint{{Time: at(1), Equity: 10}, {Time: at(3), Equity: 30}, {Time: at(10), Equity: 100}}
+	got := AlignBenchmark(equity, benchmark)
+	want := []float64{10, 10, 10, 30}
+	if len(got) != len(want) {
+		t.Fatalf("AlignBenchmark = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(equity[i].Time) || got[i].Equity != want[i] {
+			t.Errorf("AlignBenchmark = %v, want %v", got, want)
+		}
+	}
+	if got := AlignBenchmark(equity, nil); got != nil {
+		t.Errorf("AlignBenchmark of an empty benchmark = %v", got)
+	}
+}
+
+func TestCompareBenchmark(t *testing.T) {
+	// the benchmark returns are 10%, -10%, 10%
+	benchmark := equityCurve(100, 110, 99, 108.9)
+	tests := []struct {
+		name   string
+		equity []EquityPoint
+		want   BenchmarkStats
+	}{
+		{
+			// returns 20%, -20%, 20%
+			name:   "twice the benchmark",
+			equity: equityCurve(1000, 1200, 960, 1152),
+			want:   BenchmarkStats{Return: 0.089, ExcessReturn: 0.063, Alpha: 0, Beta: 2, Correlation: 1},
+		},
+		{
+			// returns -4%, 6%, -4%: 1% - half of the benchmark return
+			name:   "against the benchmark",
+			equity: equityCurve(1000, 960, 1017.6, 976.896),
+			want:   BenchmarkStats{Return: 0.089, ExcessReturn: -0.112104, Alpha: 2.52, Beta: -0.5, Correlation: -1},
+		},
+		{
+			name:   "different lengths",
+			equity: equityCurve(1000, 1100),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aligned := benchmark
+			if len(test.equity) != len(benchmark) {
+				aligned = benchmark[:1]
+			}
+			got := CompareBenchmark(test.equity, aligned, 252)
+			if !almostEqual(got.Return, test.want.Return) || !almostEqual(got.ExcessReturn, test.want.ExcessReturn) || !almostEqual(got.Alpha, test.want.Alpha) ||
+				!almostEqual(got.Beta, test.want.Beta) || !almostEqual(got.Correlation, test.want.Correlation) {
+				t.Errorf("CompareBenchmark = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	flat := equityCurve(100, 100, 100, 100)
+	got := CompareBenchmark(equityCurve(1000, 1100, 1100, 1210), flat, 252)
+	// nothing to regress on: the alpha is the mean return
+	if got.Beta != 0 || got.Correlation != 0 || !almostEqual(got.Alpha, 0.2/3*252) || !almostEqual(got.ExcessReturn, 0.21) {
+		t.Errorf("CompareBenchmark with a flat benchmark = %+v", got)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"TradingBot/analytics"
+	"flag"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backtestAccount is the account of the journal, logs and reports of a backtest
+const backtestAccount = "backtest"
+
+// moscowLocation is the time zone of the exchange, the trading days of the candles are split in it
+func moscowLocation() *time.Location {
+	location, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// candleDays splits the candles into trading days of the exchange
+func candleDays(candles []RequestToPredict) [][]RequestToPredict {
+	location := moscowLocation()
+	var (
+		days    [][]RequestToPredict
+		lastDay string
+	)
+	for _, candle := range candles {
+		day := candle.Datetime.In(location).Format("2006-01-02")
+		if len(days) == 0 || day != lastDay {
+			days = append(days, nil)
+			lastDay = day
+		}
+		days[len(days)-1] = append(days[len(days)-1], candle)
+	}
+	return days
+}
+
+// backtestCandles reads the candles of the period from the candle store or from the file
+func backtestCandles(configParams Config, ticker string, interval string, data string, from time.Time, to time.Time) ([]RequestToPredict, error) {
+	if data == "" {
+		store, err := openCandleStore(configParams.historyDir())
+		if err != nil {
+			return nil, err
+		}
+		return store.read(ticker, interval, from, to)
+	}
+	candles, err := readCandles(data)
+	if err != nil {
+		return nil, err
+	}
+	var selected []RequestToPredict
+	for _, candle := range candles {
+		if (!from.IsZero() && candle.Datetime.Before(from)) || (!to.IsZero() && !candle.Datetime.Before(to)) {
+			continue
+		}
+		selected = append(selected, candle)
+	}
+	return selected, nil
+}
+
+// backtestRun is one run of the strategy over the candles, actions are the predictions of the candles (-1 if the predictor
+// failed), so the predictor is asked once for many runs. The journal and the logs are written to dir.
+type backtestRun struct {
+	candles      []RequestToPredict
+	actions      []Signal
+	instrumentId string
+	money        float64
+	config       Config
+	fill         FillModelConfig
+	interval     time.Duration
+	dir          string
+	// reports writes the daily reports to <dir>/reports
+	reports bool
+	logger  *zap.SugaredLogger
+}
+
+type backtestResult struct {
+	Days       int
+	FinalMoney float64
+	Summary    analytics.Summary
+	Equity     []analytics.EquityPoint
+	Benchmarks []benchmarkResult
+}
+
+// predictActions sends the candles to the predictor in their order and returns the actions with the number of failed requests
+func predictActions(candles []RequestToPredict, predictor Predictor) ([]Signal, int) {
+	failed := 0
+	actions := make([]Signal, len(candles))
+	for i, candle := range candles {
+		candle.ReqId = uint64(i + 1)
+		response, err := predictor.predict(candle)
+		if err != nil {
+			actions[i] = Signal{Action: -1}
+			failed++
+			continue
+		}
+		actions[i] = response.signal()
+	}
+	return actions, failed
+}
+
+// runBacktest executes the actions by a simulated broker with the fill model of the run. Every day starts a new strategy
+// day like the live bot does, the positions are closed at the end of every day.
+func runBacktest(run backtestRun) (backtestResult, error) {
+	journalDir := filepath.Join(run.dir, "journal")
+	journal, err := openJournal(journalDir, backtestAccount)
+	if err != nil {
+		return backtestResult{}, err
+	}
+	defer journal.Close()
+	// the fill model is kept with the results, the same candles give other results with another model
+	fillData, err := yaml.Marshal(run.fill)
+	if err != nil {
+		return backtestResult{}, err
+	}
+	err = os.WriteFile(filepath.Join(run.dir, "fill_model.yaml"), fillData, 0644)
+	if err != nil {
+		return backtestResult{}, err
+	}
+
+	broker := newSimulatedBroker(run.money, run.config.feeConfig(), run.fill, run.interval, true, journal, run.logger)
+	// the records get the time of the candles, so the days of the journal are the days of the candles
+	journal.setClock(broker.now)
+	admin := newAdmin(nil)
+	reportsDir := ""
+	if run.reports {
+		reportsDir = filepath.Join(run.dir, "reports")
+	}
+
+	var (
+		dates []time.Time
+		index int
+	)
+	for _, day := range candleDays(run.candles) {
+		broker.onCandle(run.instrumentId, day[0])
+		dates = append(dates, broker.now())
+		// the channel is unbuffered: once the sync command is taken, the strategy has processed the action sent before it
+		actions := make(chan Signal)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go startStrategy(actions, strategyParams{
+			broker:       broker,
+			accountId:    backtestAccount,
+			instrumentId: run.instrumentId,
+			journal:      journal,
+			fees:         run.config.feeConfig(),
+			risk:         run.config.riskConfig(),
+			reportsDir:   reportsDir,
+			historyDir:   run.config.historyDir(),
+			benchmark:    run.config.benchmarkConfig(),
+			admin:        admin,
+			interval:     run.interval,
+			logger:       run.logger,
+		}, &wg)
+		for i, candle := range day {
+			signal := run.actions[index]
+			index++
+			broker.onCandle(run.instrumentId, candle)
+			broker.setUpcoming(run.instrumentId, day[i+1:])
+			if signal.Action < 0 {
+				continue
+			}
+			journal.record(JournalRecord{Kind: journalSignal, InstrumentID: run.instrumentId, ReqID: uint64(index), Action: signal.Action, Confidence: signal.Confidence, Price: candle.Close})
+			actions <- signal
+			admin.send(adminSync, time.Minute)
+		}
+		actions <- Signal{Action: 4}
+		wg.Wait()
+	}
+
+	var records []JournalRecord
+	for _, date := range dates {
+		dayRecords, err := readJournal(journalDir, backtestAccount, date)
+		if err != nil {
+			return backtestResult{}, err
+		}
+		records = append(records, dayRecords...)
+	}
+	summary, _, equity := performanceFromJournal(records, run.instrumentId, periodsPerTradingYear(run.interval))
+	indexCurve, err := loadIndexBenchmark(run.config.historyDir(), run.config.benchmarkConfig(), run.candles[0].Datetime, run.candles[len(run.candles)-1].Datetime.Add(run.interval))
+	if err != nil {
+		run.logger.Infof("Index benchmark is skipped: %v", err.Error())
+	}
+	// the strategy goroutines are finished, nothing else touches the broker
+	return backtestResult{
+		Days:       len(dates),
+		FinalMoney: broker.money,
+		Summary:    summary,
+		Equity:     equity,
+		Benchmarks: compareBenchmarks(records, run.instrumentId, equity, periodsPerTradingYear(run.interval), indexCurve),
+	}, nil
+}
+
+// writeEquityCurves writes the equity of the backtest with the benchmark curves scaled to the start equity:
+// time, equity, one column per benchmark
+func writeEquityCurves(path string, result backtestResult) error {
+	header := []string{"time", "equity"}
+	for _, benchmark := range result.Benchmarks {
+		header = append(header, benchmark.Name)
+	}
+	rows := [][]string{header}
+	for i, point := range result.Equity {
+		row := []string{point.Time.UTC().Format(time.RFC3339), fmt.Sprintf("%.2f", point.Equity)}
+		for _, benchmark := range result.Benchmarks {
+			row = append(row, fmt.Sprintf("%.2f", benchmark.Curve[i].Equity))
+		}
+		rows = append(rows, row)
+	}
+	return writeCSV(path, rows)
+}
+
+// backtestFlags are the candle and predictor flags shared by backtest and sweep
+type backtestFlags struct {
+	ticker, interval, data, instrumentId, from, to, predictor, out *string
+	money                                                          *float64
+}
+
+func addBacktestFlags(flags *flag.FlagSet) backtestFlags {
+	return backtestFlags{
+		ticker:       flags.String("ticker", "", "instrument in the candle store (history_dir of the config)"),
+		interval:     flags.String("interval", "1m", "candle interval in the candle store"),
+		data:         flags.String("data", "", "candle file instead of the candle store"),
+		instrumentId: flags.String("instrument", "", "instrument of the candles in the journal, the ticker or the file name by default"),
+		from:         flags.String("from", "", "first day, YYYY-MM-DD"),
+		to:           flags.String("to", "", "last day (included), YYYY-MM-DD"),
+		money:        flags.Float64("money", 205000, "start money, RUB"),
+		predictor:    flags.String("predictor", "default", "name of the predictor: predictors of the config, go_rsi or an ensemble"),
+		out:          flags.String("out", "backtests", "directory of the backtest results"),
+	}
+}
+
+// prepareBacktest reads the candles of the flags, leaves the quarantined candles out (the quality report is written to dir)
+// and asks the predictor for the actions of the candles
+func prepareBacktest(f backtestFlags, configParams Config, dir string, logger investgo.Logger) ([]RequestToPredict, []Signal, QualityReport, int) {
+	if (*f.ticker == "") == (*f.data == "") {
+		log.Fatalf("Set the candles: -ticker <ticker> for the candle store or -data <path>")
+	}
+	if *f.instrumentId == "" {
+		*f.instrumentId = *f.ticker
+		if *f.data != "" {
+			*f.instrumentId = strings.TrimSuffix(filepath.Base(*f.data), filepath.Ext(*f.data))
+		}
+	}
+	if _, ok := candleDurations[*f.interval]; !ok {
+		log.Fatalf("Unknown interval %q", *f.interval)
+	}
+	var fromTime, toTime time.Time
+	if *f.from != "" {
+		fromTime = parseDate("from", *f.from)
+	}
+	if *f.to != "" {
+		toTime = parseDate("to", *f.to).AddDate(0, 0, 1)
+	}
+	selected, err := backtestCandles(configParams, strings.ToUpper(*f.ticker), *f.interval, *f.data, fromTime, toTime)
+	if err != nil {
+		log.Fatalf("Can't read candles: %v", err)
+	}
+	if len(selected) == 0 {
+		log.Fatalf("No candles in the period")
+	}
+	selected, quality := validateCandles(*f.instrumentId, selected, candleDurations[*f.interval], configParams.dataQualityConfig())
+	_, err = writeQualityReport(dir, quality)
+	if err != nil {
+		log.Fatalf("Can't write data quality report: %v", err)
+	}
+	if len(selected) == 0 {
+		log.Fatalf("All candles of the period are quarantined")
+	}
+
+	admin := newAdmin(newPredictors(configParams, nil, logger))
+	err = admin.switchPredictor(*f.predictor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	actions, failed := predictActions(selected, admin.activePredictor())
+	if failed == len(selected) {
+		log.Fatalf("The predictor failed on all %v candles", failed)
+	}
+	return selected, actions, quality, failed
+}
+
+// runBacktestCommand runs the strategy over the stored candles: every candle is sent to the predictor and the action is
+// executed by a simulated broker with the fill model of the config (-fill changes its price).
+// The journal, the logs and the daily reports are written to <out>/<start time>:
+// ./TradingBot backtest -config <path to config file> -ticker TCSG [-interval 1m] [-from 2024-01-01] [-to 2024-02-01]
+func runBacktestCommand(args []string) {
+	flags, configFilePath := commandFlags("backtest")
+	f := addBacktestFlags(flags)
+	fillPrice := flags.String("fill", "", "price of the fill model: close, next_open or vwap, fill_model of the config by default")
+	_ = flags.Parse(args)
+
+	configParams := readOfflineConfig(*configFilePath)
+	fill := configParams.fillModelConfig()
+	if *fillPrice != "" {
+		fill.Price = *fillPrice
+	}
+	if fill.Price != fillPriceClose && fill.Price != fillPriceNextOpen && fill.Price != fillPriceVWAP {
+		log.Fatalf("Unknown fill price %q", fill.Price)
+	}
+
+	dir := filepath.Join(*f.out, time.Now().Format("2006-01-02_150405"))
+	l, err := newLogger(LogConfig{Dir: dir, Level: "info", DisableStderr: true}, backtestAccount, "tradeStats")
+	if err != nil {
+		log.Fatalf("logger creating error %v", err)
+	}
+	logger := l.Sugar()
+	defer logger.Sync()
+
+	candles, actions, quality, failed := prepareBacktest(f, configParams, dir, logger)
+	result, err := runBacktest(backtestRun{
+		candles:      candles,
+		actions:      actions,
+		instrumentId: *f.instrumentId,
+		money:        *f.money,
+		config:       configParams,
+		fill:         fill,
+		interval:     candleDurations[*f.interval],
+		dir:          dir,
+		reports:      true,
+		logger:       logger,
+	})
+	if err != nil {
+		log.Fatalf("backtest error %v", err)
+	}
+
+	err = writeEquityCurves(filepath.Join(dir, "equity.csv"), result)
+	if err != nil {
+		log.Fatalf("Can't write the equity curves: %v", err)
+	}
+
+	summary := result.Summary
+	fmt.Printf("days\t%v\n", result.Days)
+	fmt.Printf("candles\t%v\n", quality.Candles)
+	fmt.Printf("quarantined\t%v\n", quality.Quarantined)
+	fmt.Printf("gaps\t%v\n", quality.count(issueGap))
+	fmt.Printf("predictor_errors\t%v\n", failed)
+	fmt.Printf("start_money\t%.2f\n", *f.money)
+	fmt.Printf("final_money\t%.2f\n", result.FinalMoney)
+	fmt.Printf("net_pnl\t%.2f\n", summary.NetPnL)
+	fmt.Printf("fees\t%.2f\n", summary.Fees)
+	fmt.Printf("trades\t%v\n", summary.Trades)
+	fmt.Printf("win_rate\t%.4f\n", summary.WinRate)
+	fmt.Printf("profit_factor\t%.4f\n", summary.ProfitFactor)
+	fmt.Printf("max_drawdown\t%.2f\n", summary.MaxDrawdown)
+	fmt.Printf("sharpe\t%.4f\n", summary.Sharpe)
+	fmt.Printf("return\t%.4f\n", result.FinalMoney / *f.money - 1)
+	for _, benchmark := range result.Benchmarks {
+		fmt.Printf("%v_return\t%.4f\n", benchmark.Name, benchmark.Stats.Return)
+		fmt.Printf("%v_excess_return\t%.4f\n", benchmark.Name, benchmark.Stats.ExcessReturn)
+		fmt.Printf("%v_alpha\t%.4f\n", benchmark.Name, benchmark.Stats.Alpha)
+		fmt.Printf("%v_beta\t%.4f\n", benchmark.Name, benchmark.Stats.Beta)
+		fmt.Printf("%v_correlation\t%.4f\n", benchmark.Name, benchmark.Stats.Correlation)
+	}
+	fmt.Printf("fill_model\t%v\n", fill)
+	fmt.Printf("results\t%v\n", dir)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Broker executes the orders of the strategy and reports the account.
+// liveBroker sends the orders to the exchange (sandbox or real account), simulatedBroker fills them by a fill model,
+// it is used for paper trading and backtests.
+type Broker interface {
+	// now is the wall clock for the exchange and the time of the last candle for a backtest
+	now() time.Time
+	lastPrice(instrumentId string) (float64, error)
+	// positions returns the securities and the RUB money of the account and journals the snapshot
+	positions() ([]Position, float64, error)
+	buy(instrumentId string, quantity int64) (OrderResult, error)
+	sell(instrumentId string, quantity int64) (OrderResult, error)
+	// closePosition sells by market whatever the execution mode is
+	closePosition(instrumentId string, quantity int64) (OrderResult, error)
+	cancelOpenOrders(instrumentId string, reason string) (int, error)
+	pendingOrders() ([]adminOrder, error)
+}
+
+type liveBroker struct {
+	accountId         string
+	operationsService *investgo.OperationsServiceClient
+	marketDataService *investgo.MarketDataServiceClient
+	ordersService     *investgo.OrdersServiceClient
+	executor          *orderExecutor
+	journal           *Journal
+	logger            investgo.Logger
+}
+
+func newLiveBroker(client *investgo.Client, accountId string, execution ExecutionConfig, fees FeeConfig, journal *Journal, logger investgo.Logger) *liveBroker {
+	return &liveBroker{
+		accountId:         accountId,
+		operationsService: client.NewOperationsServiceClient(),
+		marketDataService: client.NewMarketDataServiceClient(),
+		ordersService:     client.NewOrdersServiceClient(),
+		executor:          newOrderExecutor(client, accountId, execution, fees, journal, logger),
+		journal:           journal,
+		logger:            logger,
+	}
+}
+
+func (b *liveBroker) now() time.Time {
+	return time.Now()
+}
+
+func (b *liveBroker) lastPrice(instrumentId string) (float64, error) {
+	return getLastPrice(b.marketDataService, instrumentId, b.logger)
+}
+
+func (b *liveBroker) positions() ([]Position, float64, error) {
+	return getAllPositions(b.operationsService, b.accountId, b.journal, b.logger)
+}
+
+func (b *liveBroker) buy(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.executor.buy(instrumentId, quantity)
+}
+
+func (b *liveBroker) sell(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.executor.sell(instrumentId, quantity)
+}
+
+func (b *liveBroker) closePosition(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.executor.market(instrumentId, pb.OrderDirection_ORDER_DIRECTION_SELL, quantity)
+}
+
+func (b *liveBroker) cancelOpenOrders(instrumentId string, reason string) (int, error) {
+	return cancelOpenOrders(b.ordersService, b.accountId, instrumentId, reason, b.journal, b.logger)
+}
+
+func (b *liveBroker) pendingOrders() ([]adminOrder, error) {
+	return pendingOrders(b.ordersService, b.accountId, b.logger)
+}
+
+// simulatedBroker fills the orders by the fill model (at the close of the last candle of the instrument by default)
+// and charges the fee model commission. Nothing is sent to the exchange, orders never stay open: the lots the model
+// doesn't give are cancelled.
+type simulatedBroker struct {
+	mu      sync.Mutex
+	money   float64
+	lots    map[string]int64
+	candles map[string]RequestToPredict
+	// upcoming are the candles after the last one, a backtest knows them and the fill model may fill in them
+	upcoming map[string][]RequestToPredict
+	fill     FillModelConfig
+	interval time.Duration
+	// candleClock makes now() the time of the last candle, it is set for backtests
+	candleClock bool
+	clock       time.Time
+	orders      int
+	fees        FeeConfig
+	journal     *Journal
+	// metrics are set for paper trading, backtests and sweeps keep them off the live exporter
+	metrics *Metrics
+	logger  investgo.Logger
+}
+
+func newSimulatedBroker(money float64, fees FeeConfig, fill FillModelConfig, interval time.Duration, candleClock bool, journal *Journal, logger investgo.Logger) *simulatedBroker {
+	return &simulatedBroker{
+		money:       money,
+		lots:        make(map[string]int64),
+		candles:     make(map[string]RequestToPredict),
+		upcoming:    make(map[string][]RequestToPredict),
+		fill:        fill,
+		interval:    interval,
+		candleClock: candleClock,
+		fees:        fees,
+		journal:     journal,
+		logger:      logger,
+	}
+}
+
+// onCandle gives the broker the last candle of the instrument, the next orders are filled after it
+func (b *simulatedBroker) onCandle(instrumentId string, candle RequestToPredict) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.candles[instrumentId] = candle
+	delete(b.upcoming, instrumentId)
+	if candle.Datetime.After(b.clock) {
+		b.clock = candle.Datetime
+	}
+}
+
+// setUpcoming gives the broker the candles that follow the last one
+func (b *simulatedBroker) setUpcoming(instrumentId string, candles []RequestToPredict) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.upcoming[instrumentId] = candles
+}
+
+func (b *simulatedBroker) now() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.candleClock && !b.clock.IsZero() {
+		return b.clock
+	}
+	return time.Now()
+}
+
+var errNoSimulatedPrice = errors.New("no candle of the instrument yet")
+
+func (b *simulatedBroker) lastPrice(instrumentId string) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	candle, ok := b.candles[instrumentId]
+	if !ok {
+		return -1.0, errNoSimulatedPrice
+	}
+	return candle.Close, nil
+}
+
+func (b *simulatedBroker) positions() ([]Position, float64, error) {
+	b.mu.Lock()
+	var positions []Position
+	for id, lots := range b.lots {
+		if lots != 0 {
+			positions = append(positions, Position{Balance: lots, Id: id})
+		}
+	}
+	money := b.money
+	b.mu.Unlock()
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Id < positions[j].Id
+	})
+	b.journal.record(JournalRecord{Kind: journalPosition, Money: money})
+	for _, pos := range positions {
+		b.journal.record(JournalRecord{Kind: journalPosition, InstrumentID: pos.Id, Balance: pos.Balance})
+	}
+	return positions, money, nil
+}
+
+func (b *simulatedBroker) buy(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.execute(instrumentId, pb.OrderDirection_ORDER_DIRECTION_BUY, quantity, true)
+}
+
+func (b *simulatedBroker) sell(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.execute(instrumentId, pb.OrderDirection_ORDER_DIRECTION_SELL, quantity, true)
+}
+
+// closePosition isn't capped by the candle volume, the position must be closed at the end of the day
+func (b *simulatedBroker) closePosition(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.execute(instrumentId, pb.OrderDirection_ORDER_DIRECTION_SELL, quantity, false)
+}
+
+func (b *simulatedBroker) cancelOpenOrders(instrumentId string, reason string) (int, error) {
+	return 0, nil
+}
+
+func (b *simulatedBroker) pendingOrders() ([]adminOrder, error) {
+	return nil, nil
+}
+
+// execute fills the market order by the fill model: a BUY is cut to what the money pays for with the commission,
+// a SELL to the position, capped orders to the volume participation of the model
+func (b *simulatedBroker) execute(instrumentId string, direction pb.OrderDirection, quantity int64, capped bool) (OrderResult, error) {
+	b.mu.Lock()
+	candle, ok := b.candles[instrumentId]
+	if !ok {
+		b.mu.Unlock()
+		return OrderResult{}, errNoSimulatedPrice
+	}
+	b.orders++
+	orderId := fmt.Sprintf("sim-%v", b.orders)
+	price, lots := b.fill.execute(direction == pb.OrderDirection_ORDER_DIRECTION_BUY, quantity, capped, candle, b.upcoming[instrumentId], b.interval)
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		for lots > 0 && float64(lots)*price+b.fees.commission(float64(lots)*price) > b.money {
+			lots--
+		}
+	} else if lots > b.lots[instrumentId] {
+		lots = b.lots[instrumentId]
+	}
+	amount := float64(lots) * price
+	commission := b.fees.commission(amount)
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		b.money -= amount + commission
+		b.lots[instrumentId] += lots
+	} else {
+		b.money += amount - commission
+		b.lots[instrumentId] -= lots
+	}
+	b.mu.Unlock()
+
+	status := pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	if lots == 0 {
+		status = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED
+	}
+	b.journal.record(JournalRecord{Kind: journalOrderRequest, InstrumentID: instrumentId, OrderID: orderId, Direction: journalDirection(direction), OrderType: pb.OrderType_ORDER_TYPE_MARKET.String(), LotsRequested: quantity})
+	b.journal.record(JournalRecord{Kind: journalOrderState, InstrumentID: instrumentId, OrderID: orderId, Direction: journalDirection(direction), Status: status.String(), LotsRequested: quantity, LotsExecuted: lots, Amount: amount})
+	b.metrics.inc(metricOrders, "direction", journalDirection(direction), "status", status.String())
+	if lots == 0 {
+		b.logger.Infof("Simulated %v of %v lots is rejected: not enough money, position or volume", journalDirection(direction), quantity)
+		return OrderResult{OrderID: orderId, LotsRequested: quantity}, fmt.Errorf("simulated order %v is rejected", orderId)
+	}
+	b.journal.record(JournalRecord{Kind: journalFill, InstrumentID: instrumentId, OrderID: orderId, Direction: journalDirection(direction), LotsExecuted: lots, Amount: amount, Commission: commission, CommissionEstimated: true})
+	b.metrics.observeFill(instrumentId, journalDirection(direction), lots, amount)
+	b.logger.Infof("Simulated %v: lotsExecuted = %v, lotsRequested = %v, price = %v, commission = %v", journalDirection(direction), lots, quantity, price, commission)
+	return OrderResult{
+		OrderID:            orderId,
+		LotsExecuted:       lots,
+		LotsRequested:      quantity,
+		PriceOrderExecuted: amount,
+		Commission:         commission,
+	}, nil
+}
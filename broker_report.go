@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BrokerReportConfig describes how long we wait for the broker report. GenerateBrokerReport may never answer,
+// so every call is given up at the deadline. Downloaded pages are cached in CacheDir and not requested again.
+type BrokerReportConfig struct {
+	DeadlineSeconds     int    `yaml:"deadline_seconds"`
+	PollIntervalSeconds int    `yaml:"poll_interval_seconds"`
+	CacheDir            string `yaml:"cache_dir"`
+	// EndOfDay requests the report of the day when the trading day is over
+	EndOfDay bool `yaml:"end_of_day"`
+}
+
+var errBrokerReportDeadline = errors.New("broker report deadline exceeded")
+
+// brokerReportPage is one cached page of the broker report converted to the journal schema
+type brokerReportPage struct {
+	PagesCount int32           `json:"pages_count"`
+	Records    []JournalRecord `json:"records"`
+}
+
+// callWithDeadline runs the blocking SDK call in a goroutine and stops waiting for it at the deadline.
+// The call itself can't be cancelled, it finishes in the background and its result is dropped.
+func callWithDeadline(deadline time.Time, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errBrokerReportDeadline
+	}
+}
+
+// brokerReportCacheDir is keyed by the day of the report, so a call that timed out resumes the task of the day
+func brokerReportCacheDir(config BrokerReportConfig, accountId string, day time.Time) string {
+	return filepath.Join(config.CacheDir, fmt.Sprintf("%s_%s", accountId, day.Format("2006-01-02")))
+}
+
+// fetchBrokerReport requests the broker report of the day [from, from+24h), polls GetBrokerReport by the task id until
+// the report is ready and returns its trades in the journal schema (kind broker_trade)
+func fetchBrokerReport(operationsService *investgo.OperationsServiceClient, accountId string, from time.Time, config BrokerReportConfig, logger investgo.Logger) ([]JournalRecord, error) {
+	deadline := time.Now().Add(time.Duration(config.DeadlineSeconds) * time.Second)
+	to := from.Add(24 * time.Hour)
+	cacheDir := brokerReportCacheDir(config, accountId, from)
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	taskIdPath := filepath.Join(cacheDir, "task_id")
+	taskIdBytes, err := os.ReadFile(taskIdPath)
+	taskId := strings.TrimSpace(string(taskIdBytes))
+	if err != nil || taskId == "" {
+		logger.Infof("Sent GenerateBrokerReport request")
+		err = callWithDeadline(deadline, func() error {
+			generateResp, err := operationsService.GenerateBrokerReport(accountId, from, to)
+			if err != nil {
+				return err
+			}
+			taskId = generateResp.GetTaskId()
+			return nil
+		})
+		logger.Infof("Got response for GenerateBrokerReport request")
+		if err != nil {
+			logger.Errorf("Can't generate broker report: %v", err.Error())
+			return nil, err
+		}
+		if taskId == "" {
+			return nil, errors.New("broker report task id is empty")
+		}
+		err = os.WriteFile(taskIdPath, []byte(taskId), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var records []JournalRecord
+	for page, pagesCount := int32(0), int32(1); page < pagesCount; page++ {
+		reportPage, err := getBrokerReportPage(operationsService, accountId, taskId, page, cacheDir, deadline, time.Duration(config.PollIntervalSeconds)*time.Second, logger)
+		if err != nil {
+			return records, err
+		}
+		pagesCount = reportPage.PagesCount
+		records = append(records, reportPage.Records...)
+	}
+	logger.Infof("Broker report %v: %v trades", taskId, len(records))
+	return records, nil
+}
+
+// getBrokerReportPage returns the cached page or polls GetBrokerReport until the page is ready or the deadline comes
+func getBrokerReportPage(operationsService *investgo.OperationsServiceClient, accountId string, taskId string, page int32, cacheDir string, deadline time.Time, pollInterval time.Duration, logger investgo.Logger) (brokerReportPage, error) {
+	var reportPage brokerReportPage
+	pagePath := filepath.Join(cacheDir, fmt.Sprintf("page_%d.json", page))
+	if data, err := os.ReadFile(pagePath); err == nil {
+		err = json.Unmarshal(data, &reportPage)
+		if err == nil {
+			return reportPage, nil
+		}
+		logger.Errorf("Broken broker report cache %v, downloading again: %v", pagePath, err.Error())
+	}
+
+	for {
+		var reportResp *investgo.GetBrokerReportResponse
+		logger.Infof("Sent GetBrokerReport request, task id = %v, page = %v", taskId, page)
+		err := callWithDeadline(deadline, func() error {
+			resp, err := operationsService.GetBrokerReport(taskId, page)
+			reportResp = resp
+			return err
+		})
+		logger.Infof("Got response for GetBrokerReport request")
+		if err == nil {
+			reportPage.PagesCount = reportResp.GetPagesCount()
+			for _, item := range reportResp.GetBrokerReport() {
+				reportPage.Records = append(reportPage.Records, brokerReportRecord(accountId, item))
+			}
+			break
+		}
+		if errors.Is(err, errBrokerReportDeadline) {
+			logger.Errorf("Broker report is not ready before the deadline, task id = %v", taskId)
+			return reportPage, err
+		}
+		// the broker answers with an error while the report is being prepared
+		logger.Infof("Broker report is not ready yet: %v", err.Error())
+		if time.Now().Add(pollInterval).After(deadline) {
+			return reportPage, errBrokerReportDeadline
+		}
+		time.Sleep(pollInterval)
+	}
+
+	data, err := json.Marshal(reportPage)
+	if err == nil {
+		err = os.WriteFile(pagePath, data, 0644)
+	}
+	if err != nil {
+		logger.Errorf("Can't cache broker report page: %v", err.Error())
+	}
+	return reportPage, nil
+}
+
+// brokerReportRecord converts one trade of the broker report to the journal schema, the ticker is kept in Message
+func brokerReportRecord(accountId string, item *pb.BrokerReport) JournalRecord {
+	direction := directionSell
+	if d := strings.ToLower(item.GetDirection()); strings.Contains(d, "покуп") || strings.Contains(d, "buy") {
+		direction = directionBuy
+	}
+	return JournalRecord{
+		Time:         item.GetTradeDatetime().AsTime(),
+		Kind:         journalBrokerTrade,
+		AccountID:    accountId,
+		OrderID:      item.GetOrderId(),
+		Direction:    direction,
+		LotsExecuted: item.GetQuantity(),
+		Price:        item.GetPrice().ToFloat(),
+		Amount:       item.GetOrderAmount().ToFloat(),
+		Commission:   item.GetBrokerCommission().ToFloat() + item.GetExchangeCommission().ToFloat() + item.GetExchangeClearingCommission().ToFloat(),
+		Message:      item.GetTicker(),
+	}
+}
+
+// brokerComparison compares the bot fills with the broker trades of one direction
+type brokerComparison struct {
+	Direction        string
+	BotLots          int64
+	BrokerLots       int64
+	BotAmount        float64
+	BrokerAmount     float64
+	BotCommission    float64
+	BrokerCommission float64
+}
+
+func compareWithBroker(botRecords []JournalRecord, brokerRecords []JournalRecord) []brokerComparison {
+	comparisons := []brokerComparison{{Direction: directionBuy}, {Direction: directionSell}}
+	for i := range comparisons {
+		c := &comparisons[i]
+		for _, rec := range botRecords {
+			if rec.Kind == journalFill && rec.Direction == c.Direction {
+				c.BotLots += rec.LotsExecuted
+				c.BotAmount += rec.Amount
+				c.BotCommission += rec.Commission
+			}
+		}
+		for _, rec := range brokerRecords {
+			if rec.Direction == c.Direction {
+				c.BrokerLots += rec.LotsExecuted
+				c.BrokerAmount += rec.Amount
+				c.BrokerCommission += rec.Commission
+			}
+		}
+	}
+	return comparisons
+}
+
+// compareDayWithBrokerReport downloads the broker report of the day and logs how it differs from the journal records of the day
+func compareDayWithBrokerReport(operationsService *investgo.OperationsServiceClient, accountId string, date time.Time, botRecords []JournalRecord, config BrokerReportConfig, logger investgo.Logger) ([]brokerComparison, error) {
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	brokerRecords, err := fetchBrokerReport(operationsService, accountId, from, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	comparisons := compareWithBroker(botRecords, brokerRecords)
+	for _, c := range comparisons {
+		logger.Infof("%v: lots bot = %v, broker = %v; amount bot = %.2f, broker = %.2f; commission bot = %.2f, broker = %.2f",
+			c.Direction, c.BotLots, c.BrokerLots, c.BotAmount, c.BrokerAmount, c.BotCommission, c.BrokerCommission)
+	}
+	return comparisons, nil
+}
+
+// runBrokerReportCommand compares the journal of a past day with the official broker report:
+// ./TradingBot broker-report -config <path to config file> -date 2006-01-02 [-account <account id>]
+func runBrokerReportCommand(args []string) {
+	flags, configFilePath := commandFlags("broker-report")
+	dateString := flags.String("date", time.Now().Add(-24*time.Hour).Format("2006-01-02"), "day of the report, YYYY-MM-DD")
+	account := flags.String("account", "", "account of the journal and the broker report, account_id of the config by default")
+	_ = flags.Parse(args)
+
+	configParams := readConfig(*configFilePath)
+	date := parseDate("date", *dateString)
+	logger := commandLogger()
+	if *account == "" {
+		*account = configParams.AccountID
+	}
+	if *account == "" {
+		logger.Fatalf("Config has no account_id, give the account with -account")
+	}
+	// the orders of paper trading never reach the broker
+	if strings.HasPrefix(*account, "paper_") {
+		logger.Fatalf("Account %v is paper trading, the broker has no report of it", *account)
+	}
+	client, closeClient := commandClient(configParams, logger)
+	defer closeClient()
+
+	records, err := readJournal(configParams.journalDir(), *account, date)
+	if err != nil {
+		logger.Fatalf("journal reading error %v", err.Error())
+	}
+	comparisons, err := compareDayWithBrokerReport(client.NewOperationsServiceClient(), *account, date, records, configParams.brokerReportConfig(), logger)
+	if err != nil {
+		logger.Fatalf("broker report error %v", err.Error())
+	}
+	fmt.Println("direction,bot_lots,broker_lots,bot_amount,broker_amount,bot_commission,broker_commission")
+	for _, c := range comparisons {
+		fmt.Printf("%v,%v,%v,%.2f,%.2f,%.2f,%.2f\n", c.Direction, c.BotLots, c.BrokerLots, c.BotAmount, c.BrokerAmount, c.BotCommission, c.BrokerCommission)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DataQualityConfig sets the checks of the candles. A spike is a close-to-close return further than SpikeSigma standard
+// deviations from the mean of the last SpikeWindow returns, a gap is at least MaxGapCandles missing candles in a row
+// inside a trading day. The reports are written to Dir.
+type DataQualityConfig struct {
+	SpikeSigma    float64 `yaml:"spike_sigma"`
+	SpikeWindow   int     `yaml:"spike_window"`
+	MaxGapCandles int     `yaml:"max_gap_candles"`
+	Dir           string  `yaml:"dir"`
+}
+
+// dataQualityConfig returns the candle checks: spikes beyond 8 sigma of the last 60 returns, gaps of 5 candles and more,
+// the reports go to "./data_quality"
+func (c Config) dataQualityConfig() DataQualityConfig {
+	quality := c.DataQuality
+	if quality.SpikeSigma <= 0 {
+		quality.SpikeSigma = 8
+	}
+	if quality.SpikeWindow <= 0 {
+		quality.SpikeWindow = 60
+	}
+	if quality.MaxGapCandles <= 0 {
+		quality.MaxGapCandles = 5
+	}
+	if quality.Dir == "" {
+		quality.Dir = "./data_quality"
+	}
+	return quality
+}
+
+// Kinds of the candle issues. A gap is only reported, the candles with other issues are quarantined:
+// they are not sent to the predictor and not used by the backtest.
+const (
+	issueGap        = "gap"
+	issueDuplicate  = "duplicate"
+	issueOutOfOrder = "out_of_order"
+	issueOHLC       = "ohlc"
+	issueSpike      = "spike"
+)
+
+// minSpikeReturns is how many returns are needed before spikes are checked
+const minSpikeReturns = 20
+
+var candleDurations = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"1d":  24 * time.Hour,
+}
+
+type CandleIssue struct {
+	Kind        string
+	Candle      RequestToPredict
+	Quarantined bool
+	Detail      string
+}
+
+// QualityReport is the result of the checks of one dataset: the candles of a trading session or a stored period
+type QualityReport struct {
+	Dataset     string
+	Candles     int
+	Quarantined int
+	Issues      []CandleIssue
+}
+
+// count returns the number of the issues of the kind
+func (r *QualityReport) count(kind string) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// CandleValidator checks the candles of one instrument in the order they come
+type CandleValidator struct {
+	config   DataQualityConfig
+	interval time.Duration
+	last     RequestToPredict
+	hasLast  bool
+	returns  []float64
+	// spike is the last quarantined spike, the next candle at its level means the market moved
+	spike  *RequestToPredict
+	report QualityReport
+}
+
+func newCandleValidator(dataset string, interval time.Duration, config DataQualityConfig) *CandleValidator {
+	return &CandleValidator{config: config, interval: interval, report: QualityReport{Dataset: dataset}}
+}
+
+// check records the issues of the candle and returns false if the candle is quarantined
+func (v *CandleValidator) check(candle RequestToPredict) bool {
+	v.report.Candles++
+	var issues []CandleIssue
+	quarantine := func(kind string, format string, args ...interface{}) {
+		issues = append(issues, CandleIssue{Kind: kind, Candle: candle, Quarantined: true, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if v.hasLast && candle.Datetime.Equal(v.last.Datetime) {
+		quarantine(issueDuplicate, "second candle of %v", candle.Datetime.UTC().Format(time.RFC3339))
+	} else if v.hasLast && candle.Datetime.Before(v.last.Datetime) {
+		quarantine(issueOutOfOrder, "after the candle of %v", v.last.Datetime.UTC().Format(time.RFC3339))
+	}
+	switch {
+	case candle.Low <= 0 || candle.Volume < 0:
+		quarantine(issueOHLC, "low %v, volume %v", candle.Low, candle.Volume)
+	case candle.Low > candle.High:
+		quarantine(issueOHLC, "low %v > high %v", candle.Low, candle.High)
+	case candle.Close < candle.Low || candle.Close > candle.High:
+		quarantine(issueOHLC, "close %v outside [%v, %v]", candle.Close, candle.Low, candle.High)
+	case candle.Open < candle.Low || candle.Open > candle.High:
+		quarantine(issueOHLC, "open %v outside [%v, %v]", candle.Open, candle.Low, candle.High)
+	}
+
+	// the night between the trading days is neither a gap nor a spike
+	sameDay := v.hasLast && tradingDay(candle.Datetime).Equal(tradingDay(v.last.Datetime))
+	if len(issues) == 0 && sameDay {
+		if missing := int(candle.Datetime.Sub(v.last.Datetime)/v.interval) - 1; v.interval < 24*time.Hour && missing >= v.config.MaxGapCandles {
+			issues = append(issues, CandleIssue{Kind: issueGap, Candle: candle, Detail: fmt.Sprintf("%v candles missing after %v", missing, v.last.Datetime.UTC().Format(time.RFC3339))})
+		}
+		r := math.Log(candle.Close / v.last.Close)
+		if sigma := v.sigma(r); sigma > v.config.SpikeSigma {
+			if v.spike != nil && v.sigma(math.Log(candle.Close/v.spike.Close)) <= v.config.SpikeSigma {
+				r = math.Log(candle.Close / v.spike.Close)
+			} else {
+				quarantine(issueSpike, "return %.4f%% is %.1f sigma from the mean", r*100, sigma)
+				v.spike = &candle
+			}
+		}
+		if len(issues) == 0 || !issues[len(issues)-1].Quarantined {
+			v.returns = append(v.returns, r)
+			if len(v.returns) > v.config.SpikeWindow {
+				v.returns = v.returns[1:]
+			}
+			v.spike = nil
+		}
+	}
+
+	v.report.Issues = append(v.report.Issues, issues...)
+	for _, issue := range issues {
+		if issue.Quarantined {
+			v.report.Quarantined++
+			return false
+		}
+	}
+	v.last = candle
+	v.hasLast = true
+	return true
+}
+
+// sigma returns how many standard deviations of the last returns the return is from their mean, 0 until there are enough returns
+func (v *CandleValidator) sigma(r float64) float64 {
+	mean, std := meanStd(v.returns)
+	if len(v.returns) < minSpikeReturns || std == 0 {
+		return 0
+	}
+	return math.Abs(r-mean) / std
+}
+
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// validateCandles checks the candles in their order and returns the ones that are not quarantined with the report
+func validateCandles(dataset string, candles []RequestToPredict, interval time.Duration, config DataQualityConfig) ([]RequestToPredict, QualityReport) {
+	validator := newCandleValidator(dataset, interval, config)
+	var good []RequestToPredict
+	for _, candle := range candles {
+		if validator.check(candle) {
+			good = append(good, candle)
+		}
+	}
+	return good, validator.report
+}
+
+// finishQualityReport writes the report and logs its summary
+func finishQualityReport(dir string, report QualityReport, logger investgo.Logger) {
+	files, err := writeQualityReport(dir, report)
+	if err != nil {
+		logger.Errorf("Can't write data quality report: %v", err.Error())
+		return
+	}
+	logger.Infof("Data quality of %v: %v candles, %v quarantined, %v gaps, report %v", report.Dataset, report.Candles, report.Quarantined, report.count(issueGap), files[0])
+}
+
+// writeQualityReport writes <dir>/<dataset>_quality.csv with the issues (the quarantined candles among them)
+// and <dir>/<dataset>_quality_summary.csv and returns their paths
+func writeQualityReport(dir string, report QualityReport) ([]string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, report.Dataset)
+	files := []string{base + "_quality.csv", base + "_quality_summary.csv"}
+
+	issues := append([]CandleIssue(nil), report.Issues...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Candle.Datetime.Before(issues[j].Candle.Datetime)
+	})
+	rows := [][]string{{"time", "issue", "quarantined", "detail", "open", "high", "low", "close", "volume"}}
+	for _, issue := range issues {
+		rows = append(rows, []string{
+			issue.Candle.Datetime.UTC().Format(time.RFC3339),
+			issue.Kind,
+			strconv.FormatBool(issue.Quarantined),
+			issue.Detail,
+			formatFloat(issue.Candle.Open),
+			formatFloat(issue.Candle.High),
+			formatFloat(issue.Candle.Low),
+			formatFloat(issue.Candle.Close),
+			strconv.FormatInt(issue.Candle.Volume, 10),
+		})
+	}
+	err = writeCSV(files[0], rows)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := [][]string{
+		{"metric", "value"},
+		{"candles", strconv.Itoa(report.Candles)},
+		{"quarantined", strconv.Itoa(report.Quarantined)},
+	}
+	for _, kind := range []string{issueGap, issueDuplicate, issueOutOfOrder, issueOHLC, issueSpike} {
+		summary = append(summary, []string{kind, strconv.Itoa(report.count(kind))})
+	}
+	err = writeCSV(files[1], summary)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
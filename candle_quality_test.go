@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCandleValidator(t *testing.T) {
+	// a Thursday
+	start := time.Date(2024, 2, 29, 10, 0, 0, 0, moscowLocation())
+	candle := func(after time.Duration, close float64) RequestToPredict {
+		return RequestToPredict{Datetime: start.Add(after), Open: close, High: close, Low: close, Close: close, Volume: 100}
+	}
+	// quiet returns a minute candle for each of the closes after the candles of the quiet minutes that move by 0.1
+	quiet := func(minutes int, closes ...float64) []RequestToPredict {
+		var candles []RequestToPredict
+		for i := 0; i < minutes; i++ {
+			candles = append(candles, candle(time.Duration(i)*time.Minute, 100+0.1*float64(i%2)))
+		}
+		for i, close := range closes {
+			candles = append(candles, candle(time.Duration(minutes+i)*time.Minute, close))
+		}
+		return candles
+	}
+	nextDay := 24 * time.Hour
+	weekend := 3 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		candles []RequestToPredict
+		// quarantined are the indexes of the quarantined candles
+		quarantined []int
+		issues      []string
+	}{
+		{
+			name:        "a spike is quarantined and the next candle at the old level is not",
+			candles:     quiet(minSpikeReturns+1, 110, 100.1),
+			quarantined: []int{minSpikeReturns + 1},
+			issues:      []string{issueSpike},
+		},
+		{
+			name:        "the market moved: the next candle at the level of the spike is accepted",
+			candles:     quiet(minSpikeReturns+1, 110, 110.1, 110),
+			quarantined: []int{minSpikeReturns + 1},
+			issues:      []string{issueSpike},
+		},
+		{
+			name:    "no spikes until there are enough returns",
+			candles: quiet(minSpikeReturns, 110),
+		},
+		{
+			name: "gaps inside a trading day",
+			candles: []RequestToPredict{
+				candle(0, 100),
+				candle(5*time.Minute, 100),
+				candle(11*time.Minute, 100),
+				candle(30*time.Minute, 100),
+			},
+			issues: []string{issueGap, issueGap},
+		},
+		{
+			name: "the nights and the weekends are not gaps or spikes",
+			candles: []RequestToPredict{
+				candle(0, 100),
+				candle(nextDay, 120),
+				candle(nextDay+time.Minute, 120),
+				candle(nextDay+weekend, 90),
+			},
+		},
+		{
+			name: "the second candle of a minute is quarantined",
+			candles: []RequestToPredict{
+				candle(0, 100),
+				candle(time.Minute, 100),
+				candle(time.Minute, 101),
+				candle(2*time.Minute, 100),
+			},
+			quarantined: []int{2},
+			issues:      []string{issueDuplicate},
+		},
+		{
+			name: "a candle before the last one is quarantined",
+			candles: []RequestToPredict{
+				candle(0, 100),
+				candle(2*time.Minute, 100),
+				candle(time.Minute, 100),
+				candle(3*time.Minute, 100),
+			},
+			quarantined: []int{2},
+			issues:      []string{issueOutOfOrder},
+		},
+		{
+			name: "a close outside the range is quarantined",
+			candles: []RequestToPredict{
+				candle(0, 100),
+				{Datetime: start.Add(time.Minute), Open: 100, High: 101, Low: 99, Close: 102, Volume: 100},
+			},
+			quarantined: []int{1},
+			issues:      []string{issueOHLC},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := newCandleValidator("TCSG", time.Minute, DataQualityConfig{SpikeSigma: 8, SpikeWindow: 60, MaxGapCandles: 5})
+			var quarantined []int
+			for i, candle := range test.candles {
+				if !validator.check(candle) {
+					quarantined = append(quarantined, i)
+				}
+			}
+			var issues []string
+			for _, issue := range validator.report.Issues {
+				issues = append(issues, issue.Kind)
+			}
+			if fmt.Sprint(quarantined) != fmt.Sprint(test.quarantined) || validator.report.Quarantined != len(test.quarantined) {
+				t.Errorf("quarantined %v (report %v), want %v", quarantined, validator.report.Quarantined, test.quarantined)
+			}
+			if strings.Join(issues, ",") != strings.Join(test.issues, ",") {
+				t.Errorf("issues %v, want %v", issues, test.issues)
+			}
+			if validator.report.Candles != len(test.candles) {
+				t.Errorf("report has %v candles, want %v", validator.report.Candles, len(test.candles))
+			}
+		})
+	}
+}
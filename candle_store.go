@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CandleStore keeps the downloaded candles partitioned by instrument, interval and trading day (Moscow time):
+// <dir>/<instrument>/<interval>/<YYYY-MM-DD>.csv, the files have the format of writeCandles.
+// A day file written after the end of the day is complete, it is never downloaded again.
+type CandleStore struct {
+	dir string
+}
+
+func openCandleStore(dir string) (*CandleStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &CandleStore{dir: dir}, nil
+}
+
+// tradingDay returns the start of the trading day of the time
+func tradingDay(t time.Time) time.Time {
+	t = t.In(moscowLocation())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (s *CandleStore) dayPath(instrument string, interval string, day time.Time) string {
+	return filepath.Join(s.dir, instrument, interval, tradingDay(day).Format("2006-01-02")+".csv")
+}
+
+// complete says the day is stored and the file was written after the end of the day
+func (s *CandleStore) complete(instrument string, interval string, day time.Time) bool {
+	info, err := os.Stat(s.dayPath(instrument, interval, day))
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(tradingDay(day).AddDate(0, 0, 1))
+}
+
+// readDay returns the stored candles of the day, nothing if the day is not stored
+func (s *CandleStore) readDay(instrument string, interval string, day time.Time) ([]RequestToPredict, error) {
+	candles, err := readCandles(s.dayPath(instrument, interval, day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return candles, err
+}
+
+// writeDay replaces the candles of the day, an empty day is stored too, so a day without trading is not downloaded again
+func (s *CandleStore) writeDay(instrument string, interval string, day time.Time, candles []RequestToPredict) error {
+	path := s.dayPath(instrument, interval, day)
+	tmpPath := path + ".tmp"
+	err := writeCandles(tmpPath, candles)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// days returns the stored days of the instrument in ascending order
+func (s *CandleStore) days(instrument string, interval string) ([]time.Time, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, instrument, interval, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, path := range paths {
+		day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(filepath.Base(path), ".csv"), moscowLocation())
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
+
+// last returns the last stored candle of the instrument, false if nothing is stored
+func (s *CandleStore) last(instrument string, interval string) (RequestToPredict, bool, error) {
+	days, err := s.days(instrument, interval)
+	if err != nil {
+		return RequestToPredict{}, false, err
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		candles, err := readCandles(s.dayPath(instrument, interval, days[i]))
+		if err != nil {
+			return RequestToPredict{}, false, err
+		}
+		if len(candles) > 0 {
+			return candles[len(candles)-1], true, nil
+		}
+	}
+	return RequestToPredict{}, false, nil
+}
+
+// read returns the stored candles of the instrument in [from, to) sorted by time, it is the reader of the backtester.
+// Zero from or to means no bound.
+func (s *CandleStore) read(instrument string, interval string, from time.Time, to time.Time) ([]RequestToPredict, error) {
+	days, err := s.days(instrument, interval)
+	if err != nil {
+		return nil, err
+	}
+	if len(days) == 0 {
+		return nil, errors.New("no candles of " + instrument + " " + interval + " in the store")
+	}
+	var candles []RequestToPredict
+	for _, day := range days {
+		if (!from.IsZero() && day.AddDate(0, 0, 1).Before(from)) || (!to.IsZero() && !day.Before(to)) {
+			continue
+		}
+		dayCandles, err := readCandles(s.dayPath(instrument, interval, day))
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range dayCandles {
+			if (!from.IsZero() && candle.Datetime.Before(from)) || (!to.IsZero() && !candle.Datetime.Before(to)) {
+				continue
+			}
+			candles = append(candles, candle)
+		}
+	}
+	return candles, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"go.uber.org/zap"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliCommand is one command of the command line, name may have two words ("sandbox open")
+type cliCommand struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+var cliCommands = []cliCommand{
+	{"run", "trade on the account of the config (default command)", runTradeCommand},
+	{"paper", "trade on real market data and predictions, orders are filled by a simulated broker", runPaperCommand},
+	{"backtest", "run the strategy over stored candles with a simulated broker", runBacktestCommand},
+	{"sweep", "run backtests over a parameter grid or random samples, optionally walk-forward", runSweepCommand},
+	{"replay", "run the strategy over a recorded session and compare its decisions", runReplayCommand},
+	{"download-history", "store historical candles of an instrument", runDownloadHistoryCommand},
+	{"report", "build the daily report of a past day from the journal", runReportCommand},
+	{"shadow-report", "compare the hypothetical profit of the primary and the shadow predictors over a past day", runShadowReportCommand},
+	{"broker-report", "compare the journal of a past day with the broker report", runBrokerReportCommand},
+	{"accounts list", "list the accounts of the token", runAccountsListCommand},
+	{"sandbox list", "list the sandbox accounts", runSandboxListCommand},
+	{"sandbox open", "open a sandbox account", runSandboxOpenCommand},
+	{"sandbox deposit", "pay money in to a sandbox account", runSandboxDepositCommand},
+	{"sandbox positions", "show the money and securities of a sandbox account", runSandboxPositionsCommand},
+	{"sandbox close", "close a sandbox account", runSandboxCloseCommand},
+	{"sandbox reset", "replace a sandbox account with a new one holding only the given money", runSandboxResetCommand},
+	{"instruments search", "find instruments by ticker, FIGI or name", runInstrumentsSearchCommand},
+	{"validate-config", "check the config file and print every problem found", runValidateConfigCommand},
+	{"notify-test", "send a test notification to every backend", runNotifyTestCommand},
+	{"notify-standin", "run a local stand-in of the notification backends", runNotifyStandInCommand},
+}
+
+// runCLI finds the command of the arguments and runs it, without a command (or with flags only) the bot trades
+func runCLI(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runTradeCommand(args)
+		return
+	}
+	if args[0] == "help" {
+		printUsage()
+		return
+	}
+	for _, command := range cliCommands {
+		words := strings.Fields(command.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != command.name {
+			continue
+		}
+		command.run(args[len(words):])
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", strings.Join(args, " "))
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %v <command> -config <path to config file> [flags]\n\nCommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, command := range cliCommands {
+		fmt.Fprintf(w, "  %v\t%v\n", command.name, command.usage)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun %v <command> -h for the flags of the command\n", os.Args[0])
+}
+
+// commandFlags returns the flag set of the command with the -config flag every command shares
+func commandFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	configFilePath := flags.String("config", "", "a filepath to the config file")
+	return flags, configFilePath
+}
+
+// commandLogger returns the logger of the one-shot commands, see newCommandLogger
+func commandLogger() *zap.SugaredLogger {
+	l, err := newCommandLogger("info")
+	if err != nil {
+		log.Fatalf("logger creating error %v", err)
+	}
+	return l.Sugar()
+}
+
+// commandClient connects to the API of the config, the returned function closes the connection
+func commandClient(configParams Config, logger *zap.SugaredLogger) (*investgo.Client, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := investgo.NewClient(ctx, investConfig(configParams), logger)
+	if err != nil {
+		cancel()
+		logger.Fatalf("client creating error %v", err.Error())
+	}
+	return client, func() {
+		err := client.Stop()
+		if err != nil {
+			logger.Errorf("client shutdown error %v", err.Error())
+		}
+		cancel()
+	}
+}
+
+// printRows prints the output of a command as tab-separated lines, the first row is the header
+func printRows(rows [][]string) {
+	for _, row := range rows {
+		fmt.Println(strings.Join(row, "\t"))
+	}
+}
+
+// runValidateConfigCommand prints every problem of the config and exits with code 1 if there are any:
+// ./TradingBot validate-config -config <path to config file>
+func runValidateConfigCommand(args []string) {
+	flags, configFilePath := commandFlags("validate-config")
+	_ = flags.Parse(args)
+
+	_, problems := loadConfig(*configFilePath, true)
+	if len(problems) != 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("config is valid")
+}
+
+// runAccountsListCommand prints the accounts the token can see, one per line:
+// ./TradingBot accounts list -config <path to config file>
+func runAccountsListCommand(args []string) {
+	flags, configFilePath := commandFlags("accounts list")
+	_ = flags.Parse(args)
+
+	configParams := readClientConfig(*configFilePath)
+	logger := commandLogger()
+	client, closeClient := commandClient(configParams, logger)
+	defer closeClient()
+
+	logger.Infof("Sent GetAccounts request")
+	accountsResp, err := client.NewUsersServiceClient().GetAccounts()
+	logger.Infof("Got response for GetAccounts request")
+	if err != nil {
+		logger.Fatalf("Can't get accounts: %v", err.Error())
+	}
+	rows := [][]string{{"id", "type", "status", "access_level", "name"}}
+	for _, account := range accountsResp.GetAccounts() {
+		rows = append(rows, []string{account.GetId(), account.GetType().String(), account.GetStatus().String(), account.GetAccessLevel().String(), account.GetName()})
+	}
+	printRows(rows)
+}
+
+// runInstrumentsSearchCommand prints the instruments found by the query, the uid is what the bot trades with:
+// ./TradingBot instruments search -config <path to config file> -query TCSG
+func runInstrumentsSearchCommand(args []string) {
+	flags, configFilePath := commandFlags("instruments search")
+	query := flags.String("query", "", "ticker, FIGI or name of the instrument")
+	_ = flags.Parse(args)
+	if *query == "" && flags.NArg() > 0 {
+		*query = strings.Join(flags.Args(), " ")
+	}
+	if *query == "" {
+		log.Fatalf("Set the query: -query <ticker, FIGI or name>")
+	}
+
+	configParams := readClientConfig(*configFilePath)
+	logger := commandLogger()
+	client, closeClient := commandClient(configParams, logger)
+	defer closeClient()
+
+	logger.Infof("Sent FindInstrument request")
+	instrumentResp, err := client.NewInstrumentsServiceClient().FindInstrument(*query)
+	logger.Infof("Got response for FindInstrument request")
+	if err != nil {
+		logger.Fatalf("Can't find instruments: %v", err.Error())
+	}
+	rows := [][]string{{"ticker", "class_code", "type", "uid", "figi", "api_trade", "name"}}
+	for _, instrument := range instrumentResp.GetInstruments() {
+		rows = append(rows, []string{instrument.GetTicker(), instrument.GetClassCode(), instrument.GetInstrumentType(), instrument.GetUid(), instrument.GetFigi(), strconv.FormatBool(instrument.GetApiTradeAvailableFlag()), instrument.GetName()})
+	}
+	printRows(rows)
+}
@@ -3,20 +3,26 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"math"
 	"net/http"
 )
 
-func send_request(request RequestToPredict, requestURL string, requestCounter *uint64, logger investgo.Logger) (ResponseAction, error) {
+// send_request asks the Python server at requestURL, failures are counted in metrics by the name of the predictor
+func send_request(request RequestToPredict, requestURL string, requestCounter *uint64, predictor string, metrics *Metrics, logger investgo.Logger) (ResponseAction, error) {
 	jsonData, err := json.Marshal(request)
 	if err != nil {
 		logger.Errorf("Cannot marshal json file to send request to the Python server: " + err.Error())
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "marshal")
 		return ResponseAction{}, err
 	}
 	logger.Infof("Marshalled json successfully")
 	req, err := http.NewRequest(http.MethodGet, requestURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		logger.Errorf("Cannot create request to the Python server: " + err.Error())
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "request")
 		return ResponseAction{}, err
 	}
 	logger.Infof("Created request successfully")
@@ -25,6 +31,7 @@ func send_request(request RequestToPredict, requestURL string, requestCounter *u
 	resp, err := http_client.Do(req)
 	if err != nil {
 		logger.Errorf("Cannot send request to the Python server: " + err.Error())
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "connection")
 		return ResponseAction{}, err
 	}
 	logger.Infof("Sent request to the Python server successfully, id = %v", *requestCounter)
@@ -34,12 +41,35 @@ func send_request(request RequestToPredict, requestURL string, requestCounter *u
 	err = decoder.Decode(&response)
 	if err != nil {
 		logger.Errorf("Cannot parse response from the Python server: %v, id = %v", err.Error(), *requestCounter)
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "decode")
 		return ResponseAction{}, err
 	}
 	if response.Error != "" {
 		logger.Errorf("Python server was not able to process the request/predict next action: %v, id = %v", response.Error, *requestCounter)
-		return ResponseAction{}, err
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "server")
+		return ResponseAction{}, errors.New(response.Error)
+	}
+	// the probabilities are of HOLD, BUY and SELL, a server without them only sends the action
+	if len(response.Probabilities) != 0 && !validProbabilities(response.Probabilities) {
+		logger.Errorf("Python server sent invalid probabilities %v, id = %v", response.Probabilities, *requestCounter)
+		metrics.inc(metricPredictorErrors, "predictor", predictor, "type", "decode")
+		return ResponseAction{}, fmt.Errorf("invalid probabilities %v", response.Probabilities)
 	}
-	logger.Infof("Got response from the Python server! Action = %v, id = %v\n", response.Action, *requestCounter)
+	logger.Infof("Got response from the Python server! Action = %v, confidence = %v, model = %v, id = %v\n", response.Action, response.confidence(), response.ModelVersion, *requestCounter)
 	return response, nil
 }
+
+// validProbabilities checks there are the probabilities of the three actions adding up to 1
+func validProbabilities(probabilities []float64) bool {
+	if len(probabilities) != 3 {
+		return false
+	}
+	sum := 0.0
+	for _, probability := range probabilities {
+		if probability < 0 || probability > 1 {
+			return false
+		}
+		sum += probability
+	}
+	return math.Abs(sum-1) < 0.01
+}
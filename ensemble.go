@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"strings"
+)
+
+// Rules of the ensembles
+const (
+	// ensembleMajority - the action of the most members
+	ensembleMajority = "majority"
+	// ensembleWeighted - the action with the biggest weighted average of the member probabilities
+	ensembleWeighted = "weighted"
+	// ensembleUnanimousBuy - majority, but BUY only if every member says BUY
+	ensembleUnanimousBuy = "unanimous_buy"
+)
+
+// EnsembleConfig combines the actions of the members by the rule. The confidence of the action is its part of the votes,
+// for "weighted" the weighted average of its probability (a member without probabilities gives 1 to its action),
+// an action below MinConfidence and a tie are HOLD. A member with Veto blocks every BUY
+// it doesn't vote for, SELL and HOLD are never blocked, so a position can always be closed.
+type EnsembleConfig struct {
+	Rule          string                 `yaml:"rule"`
+	MinConfidence float64                `yaml:"min_confidence"`
+	Members       []EnsembleMemberConfig `yaml:"members"`
+}
+
+// EnsembleMemberConfig is a predictor of the ensemble: a name of predictors or go_rsi, the weight is 1 by default
+type EnsembleMemberConfig struct {
+	Predictor string  `yaml:"predictor"`
+	Weight    float64 `yaml:"weight"`
+	Veto      bool    `yaml:"veto"`
+}
+
+type ensembleMember struct {
+	EnsembleMemberConfig
+	predictor Predictor
+}
+
+type ensemblePredictor struct {
+	name    string
+	config  EnsembleConfig
+	members []ensembleMember
+	logger  investgo.Logger
+}
+
+func newEnsemblePredictor(name string, config EnsembleConfig, members []ensembleMember, logger investgo.Logger) *ensemblePredictor {
+	if config.Rule == "" {
+		config.Rule = ensembleMajority
+	}
+	return &ensemblePredictor{name: name, config: config, members: members, logger: logger}
+}
+
+// ensembleVote is the action of one member, failed says the member didn't answer
+type ensembleVote struct {
+	member   ensembleMember
+	response ResponseAction
+	failed   bool
+}
+
+// predict asks every member, the failed members don't vote (a failed veto member blocks BUY).
+// The ensemble fails only if every member failed.
+func (e *ensemblePredictor) predict(request RequestToPredict) (ResponseAction, error) {
+	votes := make([]ensembleVote, 0, len(e.members))
+	answered := 0
+	for _, member := range e.members {
+		response, err := member.predictor.predict(request)
+		if err != nil {
+			e.logger.Errorf("Ensemble %v: predictor %v failed: %v", e.name, member.Predictor, err.Error())
+			votes = append(votes, ensembleVote{member: member, failed: true})
+			continue
+		}
+		votes = append(votes, ensembleVote{member: member, response: response})
+		answered++
+	}
+	if answered == 0 {
+		return ResponseAction{}, fmt.Errorf("every predictor of ensemble %v failed", e.name)
+	}
+	action, probabilities, reason := e.combine(votes)
+	e.logger.Infof("Ensemble %v: votes %v => action %v, probabilities %.2f%v", e.name, describeVotes(votes), action, probabilities, reason)
+	return ResponseAction{RespId: int(request.ReqId), Action: action, Probabilities: probabilities, ModelVersion: e.name}, nil
+}
+
+// combine returns the action of the votes, the probabilities of HOLD, BUY and SELL and the reason if the rule changed
+// the action to HOLD
+func (e *ensemblePredictor) combine(votes []ensembleVote) (int, []float64, string) {
+	probabilities := make([]float64, 3)
+	total := 0.0
+	for _, vote := range votes {
+		if vote.failed || vote.response.Action < 0 || vote.response.Action > 2 {
+			continue
+		}
+		weight := 1.0
+		if e.config.Rule == ensembleWeighted && vote.member.Weight > 0 {
+			weight = vote.member.Weight
+		}
+		if e.config.Rule == ensembleWeighted && len(vote.response.Probabilities) == 3 {
+			for i, probability := range vote.response.Probabilities {
+				probabilities[i] += weight * probability
+			}
+		} else {
+			probabilities[vote.response.Action] += weight
+		}
+		total += weight
+	}
+	if total == 0 {
+		return 0, nil, ", no valid votes"
+	}
+	action, tie := 0, false
+	for candidate := range probabilities {
+		probabilities[candidate] /= total
+	}
+	for candidate := 1; candidate < len(probabilities); candidate++ {
+		if probabilities[candidate] > probabilities[action] {
+			action, tie = candidate, false
+		} else if probabilities[candidate] == probabilities[action] {
+			tie = true
+		}
+	}
+	confidence := probabilities[action]
+	switch {
+	case tie:
+		return 0, probabilities, ", tie"
+	case confidence < e.config.MinConfidence:
+		return 0, probabilities, fmt.Sprintf(", below min confidence %v", e.config.MinConfidence)
+	case action != 1:
+		return action, probabilities, ""
+	}
+	for _, vote := range votes {
+		if e.config.Rule == ensembleUnanimousBuy && (vote.failed || vote.response.Action != 1) {
+			return 0, probabilities, fmt.Sprintf(", BUY is not unanimous: %v", vote.member.Predictor)
+		}
+		if vote.member.Veto && (vote.failed || vote.response.Action != 1) {
+			return 0, probabilities, fmt.Sprintf(", BUY is vetoed by %v", vote.member.Predictor)
+		}
+	}
+	return action, probabilities, ""
+}
+
+func describeVotes(votes []ensembleVote) string {
+	parts := make([]string, 0, len(votes))
+	for _, vote := range votes {
+		if vote.failed {
+			parts = append(parts, vote.member.Predictor+"=failed")
+		} else {
+			parts = append(parts, fmt.Sprintf("%v=%v", vote.member.Predictor, vote.response.Action))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
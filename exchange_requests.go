@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/russianinvestments/invest-api-go-sdk/investgo"
 	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"math"
 	"sync/atomic"
 	"time"
 )
@@ -28,7 +29,7 @@ func getInstrumentId(client *investgo.Client, logger investgo.Logger, ticker str
 	}
 	instruments := instrumentResp.GetInstruments()
 	for _, instrument := range instruments {
-		if instrument.GetTicker() == "TCSG" {
+		if instrument.GetTicker() == ticker {
 			return instrument.GetUid(), nil
 		}
 	}
@@ -83,40 +84,52 @@ func createAccountId(client *investgo.Client, sandboxService *investgo.SandboxSe
 	return newAccId, err
 }
 
-func depositMoney(sandboxService *investgo.SandboxServiceClient, accountId string, money int64, logger investgo.Logger) error {
+// depositMoney pays the amount in to the sandbox account and returns the balance of the currency after it
+func depositMoney(sandboxService *investgo.SandboxServiceClient, accountId string, amount float64, currency string, logger investgo.Logger) (float64, error) {
+	units := math.Floor(amount)
 	logger.Infof("Sent depositMoney request")
 	payInResp, err := sandboxService.SandboxPayIn(&investgo.SandboxPayInRequest{
 		AccountId: accountId,
-		Currency:  "RUB",
-		Unit:      money,
-		Nano:      0,
+		Currency:  currency,
+		Unit:      int64(units),
+		Nano:      int32(math.Round((amount - units) * 1e9)),
 	})
 	logger.Infof("Got response for depositMoney request")
 	if err != nil {
 		logger.Errorf("Can't deposit money: %v", err.Error())
-		return err
+		return 0, err
 	}
-	logger.Infof("sandbox account %v after deposition money: balance = %v\n", accountId, payInResp.GetBalance().ToFloat())
-	return nil
+	balance := payInResp.GetBalance().ToFloat()
+	logger.Infof("sandbox account %v after deposition money: balance = %v %v\n", accountId, balance, currency)
+	return balance, nil
 }
 
-func getHistoricalData(client *investgo.Client, instrumentId string, logger investgo.Logger) error {
-	// минутные свечи за последние 2 месяца
-	logger.Infof("Sent getHistoricalData request")
-	MarketDataService := client.NewMarketDataServiceClient()
-	logger.Infof("Got response for getHistoricalData request")
-	_, err := MarketDataService.GetHistoricCandles(&investgo.GetHistoricCandlesRequest{
-		Instrument: instrumentId,
-		Interval:   pb.CandleInterval_CANDLE_INTERVAL_1_MIN,
-		From:       time.Now().Add(-2 * 30 * 24 * time.Hour),
-		To:         time.Now(),
-		File:       true,
-		FileName:   "historical_data/" + instrumentId + ".csv",
-	})
+// getCandles returns the complete candles of the instrument in [from, to), the period must fit into one GetCandles request
+func getCandles(marketDataService *investgo.MarketDataServiceClient, instrumentId string, interval pb.CandleInterval, from time.Time, to time.Time, logger investgo.Logger) ([]RequestToPredict, error) {
+	logger.Infof("Sent GetCandles request")
+	candlesResp, err := marketDataService.GetCandles(instrumentId, interval, from, to)
+	logger.Infof("Got response for GetCandles request")
 	if err != nil {
 		logger.Errorf("Can not get historical data: %v", err.Error())
+		return nil, err
+	}
+	var candles []RequestToPredict
+	for _, candle := range candlesResp.GetCandles() {
+		// the candle of the current minute/hour/day is still changing
+		if !candle.GetIsComplete() {
+			continue
+		}
+		candles = append(candles, RequestToPredict{
+			Datetime: candle.GetTime().AsTime(),
+			Open:     candle.GetOpen().ToFloat(),
+			High:     candle.GetHigh().ToFloat(),
+			Low:      candle.GetLow().ToFloat(),
+			Close:    candle.GetClose().ToFloat(),
+			AdjClose: candle.GetClose().ToFloat(),
+			Volume:   candle.GetVolume(),
+		})
 	}
-	return err
+	return candles, nil
 }
 
 func getLastPrice(client *investgo.MarketDataServiceClient, instrumentId string, logger investgo.Logger) (float64, error) {
@@ -131,6 +144,9 @@ func getLastPrice(client *investgo.MarketDataServiceClient, instrumentId string,
 	return lp[0].GetPrice().ToFloat(), nil
 }
 
+// errNoCandles means the broker answered, but there was no trading in the last minute
+var errNoCandles = errors.New("got zero volume and close price")
+
 func getLastPriceAndVolume(client *investgo.Client, instrumentId string, requestCounter *uint64, logger investgo.Logger) (RequestToPredict, error) {
 	atomic.AddUint64(requestCounter, 1)
 	MarketDataService := client.NewMarketDataServiceClient()
@@ -142,12 +158,12 @@ func getLastPriceAndVolume(client *investgo.Client, instrumentId string, request
 	} else {
 		if len(candlesResp.GetCandles()) == 0 {
 			logger.Infof("There are no candles/no prices. Error response in getLastPriceAndVolume: %v", candlesResp.String())
-			return RequestToPredict{}, errors.New("got zero volume and close price")
+			return RequestToPredict{}, errNoCandles
 		}
 		candle := candlesResp.GetCandles()[0]
 		if candle.GetVolume() == 0 && candle.GetClose().ToFloat() == 0 {
 			logger.Infof("Got zero volume and close price. Error response in getLastPriceAndVolume: %v", candlesResp.String())
-			return RequestToPredict{}, errors.New("got zero volume and close price")
+			return RequestToPredict{}, errNoCandles
 		}
 		//logger.Infof("PRICE:VOLUME: candle number %d, high price = %v, volume = %v, time = %v, is complete = %v\n", i, candle.GetHigh().ToFloat(), candle.GetVolume(), candle.GetTime().AsTime(), candle.GetIsComplete())
 		return RequestToPredict{
@@ -165,7 +181,34 @@ func getLastPriceAndVolume(client *investgo.Client, instrumentId string, request
 	return RequestToPredict{}, err
 }
 
-func buy(ordersService *investgo.OrdersServiceClient, instrumentId string, accountId string, quantity int64, logger investgo.Logger) (int64, int64, float64, error) {
+// OrderResult is what the exchange executed for one trading decision, possibly by several orders (OrderID is the last one).
+// PriceOrderExecuted is the executed order price returned by the exchange, Commission is paid on top of it.
+type OrderResult struct {
+	OrderID            string
+	LotsExecuted       int64
+	LotsRequested      int64
+	PriceOrderExecuted float64
+	Commission         float64
+}
+
+func (r *OrderResult) add(other OrderResult) {
+	r.OrderID = other.OrderID
+	r.LotsExecuted += other.LotsExecuted
+	r.PriceOrderExecuted += other.PriceOrderExecuted
+	r.Commission += other.Commission
+}
+
+// orderCommission returns the executed commission, the initial (expected) one is used while the exchange hasn't calculated it yet
+func orderCommission(executed *pb.MoneyValue, initial *pb.MoneyValue) float64 {
+	if commission := executed.ToFloat(); commission != 0 {
+		return commission
+	}
+	return initial.ToFloat()
+}
+
+func buy(ordersService *investgo.OrdersServiceClient, instrumentId string, accountId string, quantity int64, journal *Journal, logger investgo.Logger) (OrderResult, error) {
+	orderId := investgo.CreateUid()
+	journal.record(JournalRecord{Kind: journalOrderRequest, InstrumentID: instrumentId, OrderID: orderId, Direction: directionBuy, OrderType: pb.OrderType_ORDER_TYPE_MARKET.String(), LotsRequested: quantity})
 	logger.Infof("Sent buy request")
 	buyResp, err := ordersService.Buy(&investgo.PostOrderRequestShort{
 		InstrumentId: instrumentId,
@@ -173,18 +216,27 @@ func buy(ordersService *investgo.OrdersServiceClient, instrumentId string, accou
 		Price:        nil,
 		AccountId:    accountId,
 		OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
-		OrderId:      investgo.CreateUid(),
+		OrderId:      orderId,
 	})
 	logger.Infof("Got response for GetCandles request")
 	if err != nil {
 		logger.Errorf("Failed to BUY: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(buyResp.GetHeader()))
-		return -1, -1, -1, err
+		journal.record(JournalRecord{Kind: journalOrderState, InstrumentID: instrumentId, OrderID: orderId, Direction: directionBuy, Status: "ERROR", Message: err.Error()})
+		botMetrics.inc(metricOrders, "direction", directionBuy, "status", "ERROR")
+		return OrderResult{}, err
 	}
+	recordOrderResponse(journal, instrumentId, directionBuy, buyResp)
 	logger.Infof("Executed BUY: order status = %v\n", buyResp.GetExecutionReportStatus().String())
-	return buyResp.GetLotsExecuted(), buyResp.GetLotsRequested(), buyResp.GetExecutedOrderPrice().ToFloat(), nil
+	return OrderResult{
+		OrderID:            buyResp.GetOrderId(),
+		LotsExecuted:       buyResp.GetLotsExecuted(),
+		LotsRequested:      buyResp.GetLotsRequested(),
+		PriceOrderExecuted: buyResp.GetExecutedOrderPrice().ToFloat(),
+		Commission:         orderCommission(buyResp.GetExecutedCommission(), buyResp.GetInitialCommission()),
+	}, nil
 }
 
-func getAllPositions(operationsService *investgo.OperationsServiceClient, accountId string, logger investgo.Logger) ([]Position, float64, error) {
+func getAllPositions(operationsService *investgo.OperationsServiceClient, accountId string, journal *Journal, logger investgo.Logger) ([]Position, float64, error) {
 	logger.Infof("Sent getAllPositions request")
 	positionsResp, err := operationsService.GetPositions(accountId)
 	logger.Infof("Got response for getAllPositions request")
@@ -192,13 +244,26 @@ func getAllPositions(operationsService *investgo.OperationsServiceClient, accoun
 		logger.Errorf("Can't get all positions: %v", err.Error())
 		return nil, 0, err
 	}
-	positions := positionsResp.GetSecurities()
-	poss := make([]Position, len(positions))
-	for i, position := range positions {
-		logger.Infof("position number %v, uid = %v, balance = %v, figi = %v, instrument_type = %v", i, position.GetInstrumentUid(), position.GetBalance(), position.GetFigi(), position.GetInstrumentType())
-		poss[i] = Position{Balance: position.GetBalance(), Id: position.GetInstrumentUid()}
+	for i, position := range positionsResp.GetSecurities() {
+		logger.Infof("position number %v, uid = %v, balance = %v, blocked = %v, figi = %v, instrument_type = %v", i, position.GetInstrumentUid(), position.GetBalance(), position.GetBlocked(), position.GetFigi(), position.GetInstrumentType())
+	}
+	poss, money := accountPositions(positionsResp)
+	journal.record(JournalRecord{Kind: journalPosition, Money: money})
+	for _, pos := range poss {
+		journal.record(JournalRecord{Kind: journalPosition, InstrumentID: pos.Id, Balance: pos.Balance})
 	}
-	return poss, positionsResp.GetMoney()[0].ToFloat(), nil
+	return poss, money, nil
+}
+
+// accountPositions returns the securities and the RUB money of the account, the lots and money blocked by active orders
+// still belong to the account. The start of the day, the restored state and the reconciliation all count them this way.
+func accountPositions(positionsResp *investgo.PositionsResponse) ([]Position, float64) {
+	securities := positionsResp.GetSecurities()
+	positions := make([]Position, len(securities))
+	for i, position := range securities {
+		positions[i] = Position{Balance: position.GetBalance() + position.GetBlocked(), Id: position.GetInstrumentUid()}
+	}
+	return positions, rubMoney(positionsResp.GetMoney()) + rubMoney(positionsResp.GetBlocked())
 }
 
 func getCurrentBalance(operationsService *investgo.OperationsServiceClient, accountId string, logger investgo.Logger) (float64, error) {
@@ -209,12 +274,14 @@ func getCurrentBalance(operationsService *investgo.OperationsServiceClient, acco
 		logger.Errorf("Can't get current balance: %v", err.Error())
 		return -1, err
 	}
-	currentBalance := positionsResp.GetMoney()[0].ToFloat()
+	currentBalance := rubMoney(positionsResp.GetMoney())
 	logger.Infof("Got current balance: %v", currentBalance)
 	return currentBalance, nil
 }
 
-func sell(ordersService *investgo.OrdersServiceClient, instrumentId string, accountId string, quantity int64, logger investgo.Logger) (int64, int64, float64, error) {
+func sell(ordersService *investgo.OrdersServiceClient, instrumentId string, accountId string, quantity int64, journal *Journal, logger investgo.Logger) (OrderResult, error) {
+	orderId := investgo.CreateUid()
+	journal.record(JournalRecord{Kind: journalOrderRequest, InstrumentID: instrumentId, OrderID: orderId, Direction: directionSell, OrderType: pb.OrderType_ORDER_TYPE_MARKET.String(), LotsRequested: quantity})
 	logger.Infof("Sent sell request")
 	sellResp, err := ordersService.Sell(&investgo.PostOrderRequestShort{
 		InstrumentId: instrumentId,
@@ -222,13 +289,37 @@ func sell(ordersService *investgo.OrdersServiceClient, instrumentId string, acco
 		Price:        nil,
 		AccountId:    accountId,
 		OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
-		OrderId:      investgo.CreateUid(),
+		OrderId:      orderId,
 	})
 	logger.Infof("Got response for sell request")
 	if err != nil {
 		logger.Errorf("Failed to SELL: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(sellResp.GetHeader()))
-		return -1, -1, -1, err
+		journal.record(JournalRecord{Kind: journalOrderState, InstrumentID: instrumentId, OrderID: orderId, Direction: directionSell, Status: "ERROR", Message: err.Error()})
+		botMetrics.inc(metricOrders, "direction", directionSell, "status", "ERROR")
+		return OrderResult{}, err
 	}
+	recordOrderResponse(journal, instrumentId, directionSell, sellResp)
 	logger.Infof("Executed SELL: order status = %v", sellResp.GetExecutionReportStatus().String())
-	return sellResp.GetLotsExecuted(), sellResp.GetLotsRequested(), sellResp.GetExecutedOrderPrice().ToFloat(), nil
+	return OrderResult{
+		OrderID:            sellResp.GetOrderId(),
+		LotsExecuted:       sellResp.GetLotsExecuted(),
+		LotsRequested:      sellResp.GetLotsRequested(),
+		PriceOrderExecuted: sellResp.GetExecutedOrderPrice().ToFloat(),
+		Commission:         orderCommission(sellResp.GetExecutedCommission(), sellResp.GetInitialCommission()),
+	}, nil
+}
+
+// recordOrderResponse writes the state of the order to the journal, the fill is recorded by the orderExecutor
+func recordOrderResponse(journal *Journal, instrumentId string, direction string, orderResp *investgo.PostOrderResponse) {
+	botMetrics.inc(metricOrders, "direction", direction, "status", orderResp.GetExecutionReportStatus().String())
+	journal.record(JournalRecord{
+		Kind:          journalOrderState,
+		InstrumentID:  instrumentId,
+		OrderID:       orderResp.GetOrderId(),
+		Direction:     direction,
+		Status:        orderResp.GetExecutionReportStatus().String(),
+		LotsRequested: orderResp.GetLotsRequested(),
+		LotsExecuted:  orderResp.GetLotsExecuted(),
+		Amount:        orderResp.GetExecutedOrderPrice().ToFloat(),
+	})
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"math"
+	"time"
+)
+
+// FeeConfig is the broker fee model. It is used when the exchange of a real account doesn't report the commission
+// of the order and for the simulated fills. The sandbox charges nothing, its fills are not estimated.
+// Percent overrides the percent of the tariff, Minimum is the smallest commission of one order in RUB.
+// OvernightMarginPercent is taken from the borrowed money for every night a margin position is held, plus OvernightFee RUB.
+type FeeConfig struct {
+	Tariff                 string  `yaml:"tariff"`
+	Percent                float64 `yaml:"percent"`
+	Minimum                float64 `yaml:"minimum"`
+	OvernightMarginPercent float64 `yaml:"overnight_margin_percent"`
+	OvernightFee           float64 `yaml:"overnight_fee"`
+}
+
+// tariffPercents are the commissions of the broker tariffs for stocks in percent of the order amount
+var tariffPercents = map[string]float64{
+	"investor": 0.3,
+	"trader":   0.05,
+	"premium":  0.04,
+}
+
+func (f FeeConfig) percent() float64 {
+	if f.Percent > 0 {
+		return f.Percent
+	}
+	return tariffPercents[f.Tariff]
+}
+
+// commission returns the expected commission of the order of the amount
+func (f FeeConfig) commission(amount float64) float64 {
+	if amount <= 0 {
+		return 0
+	}
+	commission := amount * f.percent() / 100
+	if commission < f.Minimum {
+		commission = f.Minimum
+	}
+	// the broker rounds commissions to kopecks
+	return math.Round(commission*100) / 100
+}
+
+// overnightFee returns the fee for holding the position over night, it is only taken when money was borrowed
+func (f FeeConfig) overnightFee(positionValue float64, money float64) float64 {
+	borrowed := -money
+	if borrowed <= 0 || positionValue <= 0 {
+		return 0
+	}
+	return math.Round((borrowed*f.OvernightMarginPercent/100+f.OvernightFee)*100) / 100
+}
+
+// getActualCommission returns the broker fees of the instrument charged in [from, to] according to GetOperations
+func getActualCommission(operationsService *investgo.OperationsServiceClient, accountId string, instrumentId string, from time.Time, to time.Time, logger investgo.Logger) (float64, error) {
+	logger.Infof("Sent GetOperations request")
+	operationsResp, err := operationsService.GetOperations(&investgo.GetOperationsRequest{
+		AccountId: accountId,
+		State:     pb.OperationState_OPERATION_STATE_EXECUTED,
+		From:      from,
+		To:        to,
+	})
+	logger.Infof("Got response for GetOperations request")
+	if err != nil {
+		logger.Errorf("Can't get operations: %v", err.Error())
+		return 0, err
+	}
+	commission := 0.0
+	for _, operation := range operationsResp.GetOperations() {
+		if operation.GetOperationType() != pb.OperationType_OPERATION_TYPE_BROKER_FEE || operation.GetInstrumentUid() != instrumentId {
+			continue
+		}
+		// fees are negative payments
+		commission -= operation.GetPayment().ToFloat()
+	}
+	return commission, nil
+}
+
+// applyActualCommission replaces the commissions of the day used in the statistics with the ones charged by the broker.
+// Nothing is replaced if the broker reports no fees, then the estimates stay (the sandbox has none, see brokerFeeConfig).
+func applyActualCommission(stats *TradingStatistics, operationsService *investgo.OperationsServiceClient, accountId string, instrumentId string, dayStart time.Time, journal *Journal, logger investgo.Logger) {
+	actual, err := getActualCommission(operationsService, accountId, instrumentId, dayStart, time.Now(), logger)
+	if err != nil || actual == 0 {
+		return
+	}
+	logger.Infof("Commission of the day: used in statistics = %.2f, charged by the broker = %.2f", stats.commission, actual)
+	journal.record(JournalRecord{Kind: journalCommission, InstrumentID: instrumentId, Amount: stats.commission, Commission: actual})
+	stats.money += stats.commission - actual
+	stats.commission = actual
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Price models of the simulated fills
+const (
+	fillPriceClose    = "close"
+	fillPriceNextOpen = "next_open"
+	fillPriceVWAP     = "vwap"
+)
+
+// FillModelConfig describes how the simulated broker fills a market order. The order is sent at the end of the signal
+// candle and reaches the market LatencyMs later. Price "close" fills at the close of the last candle finished by then
+// (the signal candle without latency), "next_open" at the open of the first candle starting after it and "vwap" at
+// the typical price (high + low + close) / 3 of the candle it arrives in, the candles have no better volume profile.
+// MaxParticipation caps the lots of one order by that part of the candle volume, SlippageBps and SpreadFraction
+// (part of the high-low range of the candle) move the price against the order.
+type FillModelConfig struct {
+	Price            string  `yaml:"price"`
+	LatencyMs        int     `yaml:"latency_ms"`
+	MaxParticipation float64 `yaml:"max_participation"`
+	SlippageBps      float64 `yaml:"slippage_bps"`
+	SpreadFraction   float64 `yaml:"spread_fraction"`
+}
+
+// fillModelConfig returns the fill model, the close of the signal candle without slippage by default
+func (c Config) fillModelConfig() FillModelConfig {
+	fill := c.FillModel
+	if fill.Price == "" {
+		fill.Price = fillPriceClose
+	}
+	return fill
+}
+
+func (f FillModelConfig) String() string {
+	parts := []string{f.Price}
+	if f.LatencyMs > 0 {
+		parts = append(parts, fmt.Sprintf("latency %vms", f.LatencyMs))
+	}
+	if f.MaxParticipation > 0 {
+		parts = append(parts, fmt.Sprintf("max participation %v%%", f.MaxParticipation*100))
+	}
+	if f.SlippageBps > 0 {
+		parts = append(parts, fmt.Sprintf("slippage %v bps", f.SlippageBps))
+	}
+	if f.SpreadFraction > 0 {
+		parts = append(parts, fmt.Sprintf("spread %v of the range", f.SpreadFraction))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fillBar returns the candle the order is filled in and the price before slippage. last is the signal candle,
+// upcoming are the candles after it if they are known (backtests), without them the order is filled at the close of last.
+func (f FillModelConfig) fillBar(last RequestToPredict, upcoming []RequestToPredict, interval time.Duration) (RequestToPredict, float64) {
+	arrival := last.Datetime.Add(interval + time.Duration(f.LatencyMs)*time.Millisecond)
+	switch f.Price {
+	case fillPriceNextOpen:
+		for _, candle := range upcoming {
+			if !candle.Datetime.Before(arrival) {
+				return candle, candle.Open
+			}
+		}
+	case fillPriceVWAP:
+		for _, candle := range upcoming {
+			if candle.Datetime.Add(interval).After(arrival) {
+				return candle, (candle.High + candle.Low + candle.Close) / 3
+			}
+		}
+	default:
+		bar := last
+		for _, candle := range upcoming {
+			if candle.Datetime.Add(interval).After(arrival) {
+				break
+			}
+			bar = candle
+		}
+		return bar, bar.Close
+	}
+	return last, last.Close
+}
+
+// execute returns the fill price and the lots the market gives the order, capped says the participation cap applies
+func (f FillModelConfig) execute(buy bool, lots int64, capped bool, last RequestToPredict, upcoming []RequestToPredict, interval time.Duration) (float64, int64) {
+	bar, price := f.fillBar(last, upcoming, interval)
+	if capped && f.MaxParticipation > 0 {
+		if available := int64(float64(bar.Volume) * f.MaxParticipation); lots > available {
+			lots = available
+		}
+	}
+	slippage := price*f.SlippageBps/10000 + f.SpreadFraction*(bar.High-bar.Low)
+	if buy {
+		return price + slippage, lots
+	}
+	return price - slippage, lots
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFillModel(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, moscowLocation())
+	signal := RequestToPredict{Datetime: start, Open: 99, High: 101, Low: 98, Close: 100, Volume: 1000}
+	upcoming := []RequestToPredict{
+		{Datetime: start.Add(time.Minute), Open: 102, High: 106, Low: 101, Close: 104, Volume: 500},
+		{Datetime: start.Add(2 * time.Minute), Open: 105, High: 110, Low: 104, Close: 108, Volume: 200},
+		{Datetime: start.Add(3 * time.Minute), Open: 109, High: 112, Low: 107, Close: 111, Volume: 300},
+	}
+	// latency puts the arrival of the order in the middle of the second upcoming candle
+	const latency = 90000
+
+	tests := []struct {
+		name     string
+		fill     FillModelConfig
+		buy      bool
+		lots     int64
+		capped   bool
+		upcoming []RequestToPredict
+		// bar is the start of the candle the order is filled in
+		bar   time.Time
+		price float64
+		want  int64
+	}{
+		{name: "close of the signal candle", fill: FillModelConfig{Price: fillPriceClose}, upcoming: upcoming, bar: start, price: 100},
+		{name: "close of the last candle finished before the arrival", fill: FillModelConfig{Price: fillPriceClose, LatencyMs: latency}, upcoming: upcoming, bar: upcoming[0].Datetime, price: 104},
+		{name: "open of the next candle", fill: FillModelConfig{Price: fillPriceNextOpen}, upcoming: upcoming, bar: upcoming[0].Datetime, price: 102},
+		{name: "open of the first candle after the arrival", fill: FillModelConfig{Price: fillPriceNextOpen, LatencyMs: latency}, upcoming: upcoming, bar: upcoming[2].Datetime, price: 109},
+		{name: "typical price of the next candle", fill: FillModelConfig{Price: fillPriceVWAP}, upcoming: upcoming, bar: upcoming[0].Datetime, price: (106 + 101 + 104) / 3.0},
+		{name: "typical price of the candle of the arrival", fill: FillModelConfig{Price: fillPriceVWAP, LatencyMs: latency}, upcoming: upcoming, bar: upcoming[1].Datetime, price: (110 + 104 + 108) / 3.0},
+		{name: "next open without the upcoming candles fills at the close", fill: FillModelConfig{Price: fillPriceNextOpen, LatencyMs: latency}, bar: start, price: 100},
+		{name: "vwap without the upcoming candles fills at the close", fill: FillModelConfig{Price: fillPriceVWAP}, bar: start, price: 100},
+		{name: "participation cap truncates the lots", fill: FillModelConfig{Price: fillPriceNextOpen, MaxParticipation: 0.1}, lots: 80, capped: true, upcoming: upcoming, bar: upcoming[0].Datetime, price: 102, want: 50},
+		{name: "participation cap of the candle of the arrival", fill: FillModelConfig{Price: fillPriceVWAP, LatencyMs: latency, MaxParticipation: 0.1}, lots: 80, capped: true, upcoming: upcoming, bar: upcoming[1].Datetime, price: (110 + 104 + 108) / 3.0, want: 20},
+		{name: "participation cap leaves a small order", fill: FillModelConfig{Price: fillPriceNextOpen, MaxParticipation: 0.1}, lots: 30, capped: true, upcoming: upcoming, bar: upcoming[0].Datetime, price: 102, want: 30},
+		{name: "uncapped order ignores the participation", fill: FillModelConfig{Price: fillPriceNextOpen, MaxParticipation: 0.1}, lots: 80, upcoming: upcoming, bar: upcoming[0].Datetime, price: 102, want: 80},
+		{name: "slippage and spread raise a buy", fill: FillModelConfig{Price: fillPriceClose, SlippageBps: 10, SpreadFraction: 0.1}, buy: true, bar: start, price: 100 + 0.1 + 0.3},
+		{name: "slippage and spread lower a sell", fill: FillModelConfig{Price: fillPriceClose, SlippageBps: 10, SpreadFraction: 0.1}, bar: start, price: 100 - 0.1 - 0.3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bar, _ := test.fill.fillBar(signal, test.upcoming, time.Minute)
+			if !bar.Datetime.Equal(test.bar) {
+				t.Errorf("fillBar = candle of %v, want %v", bar.Datetime, test.bar)
+			}
+			// the cases about the price sell 10 lots
+			lots, want := test.lots, test.want
+			if lots == 0 {
+				lots, want = 10, 10
+			}
+			price, got := test.fill.execute(test.buy, lots, test.capped, signal, test.upcoming, time.Minute)
+			if math.Abs(price-test.price) > 1e-9 || got != want {
+				t.Errorf("execute = %v, %v lots, want %v, %v lots", price, got, test.price, want)
+			}
+		})
+	}
+}
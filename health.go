@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthConfig sets when the bot is considered stuck: the main loop has to tick at least every MaxTickAgeSeconds
+type HealthConfig struct {
+	MaxTickAgeSeconds int `yaml:"max_tick_age_seconds"`
+}
+
+// Health keeps what the main loop last saw, /healthz and /readyz are answered from it
+type Health struct {
+	mu            sync.Mutex
+	startTime     time.Time
+	lastTick      time.Time
+	maxTickAge    time.Duration
+	brokerChecked bool
+	brokerError   string
+	instrumentId  string
+	session       string
+	predictorAddr string
+}
+
+// botHealth is updated by the main loop and read by the monitoring server
+var botHealth = &Health{startTime: time.Now(), maxTickAge: 180 * time.Second}
+
+const (
+	sessionOpen   = "open"
+	sessionClosed = "closed"
+)
+
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// configure sets the allowed tick age and the address of the Python server checked by /readyz
+func (h *Health) configure(config HealthConfig, predictorAddr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if config.MaxTickAgeSeconds > 0 {
+		h.maxTickAge = time.Duration(config.MaxTickAgeSeconds) * time.Second
+	}
+	h.predictorAddr = predictorAddr
+}
+
+func (h *Health) tick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastTick = time.Now()
+}
+
+// setBroker records the result of the last request to the broker, nil means the broker answered
+func (h *Health) setBroker(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.brokerChecked = true
+	h.brokerError = ""
+	if err != nil {
+		h.brokerError = err.Error()
+	}
+}
+
+func (h *Health) setInstrument(instrumentId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.instrumentId = instrumentId
+}
+
+func (h *Health) setSession(open bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.session = sessionClosed
+	if open {
+		h.session = sessionOpen
+	}
+}
+
+// liveness: the process answers and the main loop ticked recently. Until the first tick the start time is used.
+func (h *Health) liveness() map[string]healthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last := h.lastTick
+	if last.IsZero() {
+		last = h.startTime
+	}
+	age := time.Since(last)
+	return map[string]healthCheck{
+		"process": {OK: true, Detail: fmt.Sprintf("up %v", time.Since(h.startTime).Round(time.Second))},
+		"loop":    {OK: age <= h.maxTickAge, Detail: fmt.Sprintf("last tick %v ago, allowed %v", age.Round(time.Second), h.maxTickAge)},
+	}
+}
+
+// readiness: the broker answered the last request, the Python server accepts connections,
+// the instrument is resolved and the loop knows whether the exchange is open
+func (h *Health) readiness() map[string]healthCheck {
+	h.mu.Lock()
+	checks := map[string]healthCheck{
+		"broker":     {OK: h.brokerChecked && h.brokerError == "", Detail: h.brokerError},
+		"instrument": {OK: h.instrumentId != "", Detail: h.instrumentId},
+		"session":    {OK: h.session != "", Detail: h.session},
+	}
+	if !h.brokerChecked {
+		checks["broker"] = healthCheck{Detail: "no requests yet"}
+	}
+	predictorAddr := h.predictorAddr
+	h.mu.Unlock()
+
+	// the dial is done without the lock, it may take up to a second
+	conn, err := net.DialTimeout("tcp", predictorAddr, time.Second)
+	if err != nil {
+		checks["predictor"] = healthCheck{Detail: err.Error()}
+	} else {
+		conn.Close()
+		checks["predictor"] = healthCheck{OK: true, Detail: predictorAddr}
+	}
+	return checks
+}
+
+// writeHealth answers 200 if all checks passed and 503 otherwise
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	response := healthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (h *Health) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.liveness())
+}
+
+func (h *Health) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.readiness())
+}
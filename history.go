@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// candleIntervals are the intervals accepted by download-history
+var candleIntervals = map[string]pb.CandleInterval{
+	"1m":  pb.CandleInterval_CANDLE_INTERVAL_1_MIN,
+	"5m":  pb.CandleInterval_CANDLE_INTERVAL_5_MIN,
+	"15m": pb.CandleInterval_CANDLE_INTERVAL_15_MIN,
+	"1h":  pb.CandleInterval_CANDLE_INTERVAL_HOUR,
+	"1d":  pb.CandleInterval_CANDLE_INTERVAL_DAY,
+}
+
+var candleHeader = []string{"time", "open", "high", "low", "close", "volume"}
+
+// writeCandles stores the candles as CSV: time (RFC 3339, UTC), open, high, low, close, volume
+func writeCandles(path string, candles []RequestToPredict) error {
+	rows := [][]string{candleHeader}
+	for _, candle := range candles {
+		rows = append(rows, []string{
+			candle.Datetime.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(candle.Open, 'f', -1, 64),
+			strconv.FormatFloat(candle.High, 'f', -1, 64),
+			strconv.FormatFloat(candle.Low, 'f', -1, 64),
+			strconv.FormatFloat(candle.Close, 'f', -1, 64),
+			strconv.FormatInt(candle.Volume, 10),
+		})
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return writeCSV(path, rows)
+}
+
+// readCandles reads the candles stored by writeCandles sorted by time
+func readCandles(path string) ([]RequestToPredict, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(candleHeader)
+	var candles []RequestToPredict
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && row[0] == candleHeader[0] {
+			continue
+		}
+		candle, err := parseCandle(row)
+		if err != nil {
+			return nil, fmt.Errorf("%v, line %v: %w", path, line, err)
+		}
+		candles = append(candles, candle)
+	}
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].Datetime.Before(candles[j].Datetime)
+	})
+	return candles, nil
+}
+
+func parseCandle(row []string) (RequestToPredict, error) {
+	var (
+		candle RequestToPredict
+		err    error
+	)
+	candle.Datetime, err = time.Parse(time.RFC3339, row[0])
+	if err != nil {
+		return candle, err
+	}
+	prices := []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close}
+	for i, price := range prices {
+		*price, err = strconv.ParseFloat(row[i+1], 64)
+		if err != nil {
+			return candle, err
+		}
+	}
+	candle.AdjClose = candle.Close
+	candle.Volume, err = strconv.ParseInt(row[5], 10, 64)
+	return candle, err
+}
+
+// parseDate parses the YYYY-MM-DD flag in the local time zone
+func parseDate(name string, value string) time.Time {
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		log.Fatalf("Wrong %v %q: %v", name, value, err)
+	}
+	return date
+}
+
+// downloadHistory stores the candles of the instrument day by day. The days that are complete in the store are skipped,
+// an incomplete day is continued from its last stored candle. One GetCandles request is sent per day, pace limits the request rate.
+// The number of downloaded days and candles is returned.
+func downloadHistory(marketDataService *investgo.MarketDataServiceClient, store *CandleStore, instrument string, instrumentId string, intervalName string, from time.Time, to time.Time, pace <-chan time.Time, logger investgo.Logger) (int, int, error) {
+	interval := candleIntervals[intervalName]
+	days, candles := 0, 0
+	now := time.Now()
+	for day := tradingDay(from); day.Before(to) && day.Before(now); day = day.AddDate(0, 0, 1) {
+		if store.complete(instrument, intervalName, day) {
+			continue
+		}
+		stored, err := store.readDay(instrument, intervalName, day)
+		if err != nil {
+			return days, candles, err
+		}
+		start := day
+		// the last stored candle is requested again, the rest of the day follows it
+		if len(stored) > 0 {
+			start = stored[len(stored)-1].Datetime
+			stored = stored[:len(stored)-1]
+		}
+		<-pace
+		dayCandles, err := getCandles(marketDataService, instrumentId, interval, start, day.AddDate(0, 0, 1), logger)
+		if err != nil {
+			return days, candles, err
+		}
+		err = store.writeDay(instrument, intervalName, day, append(stored, dayCandles...))
+		if err != nil {
+			return days, candles, err
+		}
+		days++
+		candles += len(dayCandles)
+	}
+	return days, candles, nil
+}
+
+// runDownloadHistoryCommand stores the candles of the instruments in the candle store (history_dir of the config) and prints
+// what was downloaded. The days already stored are not requested again. The stored candles of the period are checked,
+// the data quality report of every instrument is written to the dir of data_quality:
+// ./TradingBot download-history -config <path to config file> -tickers TCSG,SBER -from 2024-01-01 -to 2024-03-01 [-interval 1m]
+func runDownloadHistoryCommand(args []string) {
+	flags, configFilePath := commandFlags("download-history")
+	tickers := flags.String("tickers", "TCSG", "comma-separated tickers of the instruments")
+	from := flags.String("from", time.Now().AddDate(0, -2, 0).Format("2006-01-02"), "first day, YYYY-MM-DD")
+	to := flags.String("to", time.Now().Format("2006-01-02"), "last day (included), YYYY-MM-DD")
+	intervalName := flags.String("interval", "1m", "candle interval: 1m, 5m, 15m, 1h or 1d")
+	rate := flags.Int("rate", 120, "maximum GetCandles requests per minute")
+	_ = flags.Parse(args)
+
+	if _, ok := candleIntervals[*intervalName]; !ok {
+		log.Fatalf("Unknown interval %q", *intervalName)
+	}
+	if *rate <= 0 {
+		log.Fatalf("Wrong rate %v", *rate)
+	}
+	configParams := readClientConfig(*configFilePath)
+	store, err := openCandleStore(configParams.historyDir())
+	if err != nil {
+		log.Fatalf("Can't open the candle store: %v", err)
+	}
+	logger := commandLogger()
+	client, closeClient := commandClient(configParams, logger)
+	defer closeClient()
+	marketDataService := client.NewMarketDataServiceClient()
+	pace := time.NewTicker(time.Minute / time.Duration(*rate))
+	defer pace.Stop()
+
+	failed := false
+	quality := configParams.dataQualityConfig()
+	rows := [][]string{{"ticker", "interval", "days", "candles", "last_candle", "quarantined", "gaps"}}
+	for _, ticker := range strings.Split(*tickers, ",") {
+		ticker = strings.ToUpper(strings.TrimSpace(ticker))
+		if ticker == "" {
+			continue
+		}
+		instrumentId, err := getInstrumentId(client, logger, ticker)
+		if err != nil {
+			failed = true
+			continue
+		}
+		fromTime, toTime := parseDate("from", *from), parseDate("to", *to).AddDate(0, 0, 1)
+		days, candles, err := downloadHistory(marketDataService, store, ticker, instrumentId, *intervalName, fromTime, toTime, pace.C, logger)
+		if err != nil {
+			logger.Errorf("Download of %v stopped, the next run continues from the last stored day: %v", ticker, err.Error())
+			failed = true
+		}
+		lastCandle := ""
+		if last, ok, _ := store.last(ticker, *intervalName); ok {
+			lastCandle = last.Datetime.UTC().Format(time.RFC3339)
+		}
+		quarantined, gaps := "", ""
+		if stored, err := store.read(ticker, *intervalName, fromTime, toTime); err == nil {
+			_, report := validateCandles(fmt.Sprintf("%s_%s_%s_%s", ticker, *intervalName, *from, *to), stored, candleDurations[*intervalName], quality)
+			finishQualityReport(quality.Dir, report, logger)
+			quarantined, gaps = strconv.Itoa(report.Quarantined), strconv.Itoa(report.count(issueGap))
+		}
+		rows = append(rows, []string{ticker, *intervalName, strconv.Itoa(days), strconv.Itoa(candles), lastCandle, quarantined, gaps})
+	}
+	printRows(rows)
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"net/http"
+	"time"
+)
+
+// startHTTPServer serves the monitoring endpoints of the bot in the background
+func startHTTPServer(addr string, logger investgo.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", botMetrics.handler)
+	mux.HandleFunc("/healthz", botHealth.healthzHandler)
+	mux.HandleFunc("/readyz", botHealth.readyzHandler)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.Infof("Monitoring server is listening on %v", addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Monitoring server error: %v", err.Error())
+		}
+	}()
+	return server
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of the trade journal records
+const (
+	// signal - action predicted by the Python server for the candle (ReqID, Action, Confidence, ModelVersion, Price = candle close, Candle)
+	journalSignal = "signal"
+	// shadow_signal - action of a shadow predictor for the same candle (ReqID, Action, Confidence, ModelVersion, Price, Candle,
+	// predictor name in Message)
+	journalShadowSignal = "shadow_signal"
+	// order_request - order sent to the exchange (OrderID, Direction, OrderType, LotsRequested, Price for limit orders)
+	journalOrderRequest = "order_request"
+	// order_state - state of the order reported by the exchange (OrderID, Status, LotsExecuted, Amount)
+	journalOrderState = "order_state"
+	// fill - lots executed by one order (OrderID, Direction, LotsExecuted, Amount, Commission, CommissionEstimated)
+	journalFill = "fill"
+	// position - snapshot of the account (InstrumentID, Balance, Money)
+	journalPosition = "position"
+	// reconciliation - position and money reported by the broker during reconciliation, drift in Message
+	journalReconciliation = "reconciliation"
+	// commission - actual commission of the day from GetOperations (Commission) replacing the estimated one (Amount)
+	journalCommission = "commission"
+	// broker_trade - trade from the official broker report (OrderID, Direction, LotsExecuted, Price, Amount, Commission, ticker in Message),
+	// kept in the broker report cache, not in the journal
+	journalBrokerTrade = "broker_trade"
+)
+
+const (
+	directionBuy  = "BUY"
+	directionSell = "SELL"
+)
+
+// JournalRecord is one line of the trade journal. Fields that don't make sense for the record kind are omitted.
+type JournalRecord struct {
+	Time                time.Time `json:"time"`
+	Kind                string    `json:"kind"`
+	AccountID           string    `json:"account_id"`
+	InstrumentID        string    `json:"instrument_id,omitempty"`
+	ReqID               uint64    `json:"req_id,omitempty"`
+	Action              int       `json:"action,omitempty"`
+	OrderID             string    `json:"order_id,omitempty"`
+	Direction           string    `json:"direction,omitempty"`
+	OrderType           string    `json:"order_type,omitempty"`
+	Status              string    `json:"status,omitempty"`
+	LotsRequested       int64     `json:"lots_requested,omitempty"`
+	LotsExecuted        int64     `json:"lots_executed,omitempty"`
+	Price               float64   `json:"price,omitempty"`
+	Amount              float64   `json:"amount,omitempty"`
+	Commission          float64   `json:"commission,omitempty"`
+	CommissionEstimated bool      `json:"commission_estimated,omitempty"`
+	Balance             int64     `json:"balance,omitempty"`
+	Money               float64   `json:"money,omitempty"`
+	Message             string    `json:"message,omitempty"`
+	Confidence          float64   `json:"confidence,omitempty"`
+	ModelVersion        string    `json:"model_version,omitempty"`
+	// Candle of the signals, the shadow comparison replays the day over them
+	Candle *RequestToPredict `json:"candle,omitempty"`
+}
+
+// Journal is an append-only JSONL store of everything the bot did, one file per day and account:
+// <dir>/<2006-01-02>_<account id>.jsonl. All methods can be called on a nil *Journal, then nothing is written.
+type Journal struct {
+	mu        sync.Mutex
+	dir       string
+	accountId string
+	date      string
+	file      *os.File
+	// events gets a copy of every record, it is the structured trade-event stream for the log collectors
+	events *zap.Logger
+	// clock gives the time of the records, the backtest sets it to the time of the candles
+	clock func() time.Time
+}
+
+func openJournal(dir string, accountId string) (*Journal, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{dir: dir, accountId: accountId}, nil
+}
+
+func journalFileName(dir string, accountId string, date time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.jsonl", date.Format("2006-01-02"), accountId))
+}
+
+// record appends the record to the file of the current day, the time and account id are filled in if they are empty
+func (j *Journal) record(rec JournalRecord) {
+	if j == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+		if j.clock != nil {
+			rec.Time = j.clock()
+		}
+	}
+	if rec.AccountID == "" {
+		rec.AccountID = j.accountId
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Cannot marshal journal record: %v", err)
+		return
+	}
+	if j.events != nil {
+		j.events.Info(rec.Kind, zap.Reflect("event", json.RawMessage(line)))
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if date := rec.Time.Format("2006-01-02"); j.file == nil || date != j.date {
+		if j.file != nil {
+			j.file.Close()
+		}
+		j.file, err = os.OpenFile(journalFileName(j.dir, j.accountId, rec.Time), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Printf("Cannot open journal file: %v", err)
+			j.file = nil
+			return
+		}
+		j.date = date
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("Cannot write journal record: %v", err)
+	}
+}
+
+func (j *Journal) setEventLogger(events *zap.Logger) {
+	if j == nil {
+		return
+	}
+	j.events = events
+}
+
+func (j *Journal) setClock(clock func() time.Time) {
+	if j == nil {
+		return
+	}
+	j.clock = clock
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// records returns the records of the journal account for the day
+func (j *Journal) records(date time.Time) ([]JournalRecord, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return readJournal(j.dir, j.accountId, date)
+}
+
+// readJournal returns all records of the account for the day, a missing file means there were no records
+func readJournal(dir string, accountId string, date time.Time) ([]JournalRecord, error) {
+	file, err := os.Open(journalFileName(dir, accountId, date))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []JournalRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec JournalRecord
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			// the last line may be cut if the process was killed while writing
+			return records, fmt.Errorf("journal %v, line %v: %w", file.Name(), line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
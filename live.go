@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"os"
+	"strings"
+)
+
+// Modes of the bot: sandbox trades on a sandbox account, paper fills the orders by a simulated broker,
+// live trades with real money and only starts after the checks of prepareLive
+const (
+	modeSandbox = "sandbox"
+	modePaper   = "paper"
+	modeLive    = "live"
+)
+
+const (
+	sandboxEndpoint = "sandbox-invest-public-api.tinkoff.ru"
+	liveEndpoint    = "invest-public-api.tinkoff.ru"
+)
+
+// LiveConfig is the arming of the live mode: the file has to contain the id of the account, it is the alternative to the -arm flag
+type LiveConfig struct {
+	ArmFile string `yaml:"arm_file"`
+}
+
+// RiskConfig limits what the strategy may do in a day, 0 means no limit.
+// PositionFraction is the part of the money a BUY may use, MaxDailyLoss (RUB) and MaxDailyLossPercent (of the start money)
+// stop new entries once the money of the day fell that much, MaxTrades stops new entries after that many closed trades,
+// MaxPositionLots caps one BUY. MinConfidence skips the entries the predictor is less sure of, ConfidenceSizing scales
+// the money of a BUY by the confidence.
+type RiskConfig struct {
+	PositionFraction    float64 `yaml:"position_fraction"`
+	MaxDailyLoss        float64 `yaml:"max_daily_loss"`
+	MaxDailyLossPercent float64 `yaml:"max_daily_loss_percent"`
+	MaxTrades           int     `yaml:"max_trades"`
+	MaxPositionLots     int64   `yaml:"max_position_lots"`
+	MinConfidence       float64 `yaml:"min_confidence"`
+	ConfidenceSizing    bool    `yaml:"confidence_sizing"`
+}
+
+// mode returns the mode of the config, sandbox by default
+func (c Config) mode() string {
+	if c.Mode == "" {
+		return modeSandbox
+	}
+	return c.Mode
+}
+
+// targetAPI returns target_api or the endpoint of the mode
+func (c Config) targetAPI() string {
+	if c.TargetAPI != "" {
+		return c.TargetAPI
+	}
+	if c.mode() == modeLive {
+		return liveEndpoint
+	}
+	return sandboxEndpoint
+}
+
+// liveConfig returns the arming settings, the arm file is "./ARMED" by default
+func (c Config) liveConfig() LiveConfig {
+	live := c.Live
+	if live.ArmFile == "" {
+		live.ArmFile = "./ARMED"
+	}
+	return live
+}
+
+// riskConfig returns the risk limits. Without limits in the config the sandbox and paper modes use all the money without
+// other limits, the live mode uses half of the money, stops new entries after a loss of 2% of the day start money
+// and after 10 closed trades.
+func (c Config) riskConfig() RiskConfig {
+	risk := c.Risk
+	if risk.PositionFraction <= 0 {
+		risk.PositionFraction = 1
+		if c.mode() == modeLive {
+			risk.PositionFraction = 0.5
+		}
+	}
+	if c.mode() == modeLive {
+		if risk.MaxDailyLoss == 0 && risk.MaxDailyLossPercent == 0 {
+			risk.MaxDailyLossPercent = 2
+		}
+		if risk.MaxTrades == 0 {
+			risk.MaxTrades = 10
+		}
+	}
+	return risk
+}
+
+// dailyLossLimit returns the loss of the day (RUB) after which no new position is opened, 0 - no limit
+func (r RiskConfig) dailyLossLimit(startMoney float64) float64 {
+	limit := r.MaxDailyLoss
+	if byPercent := startMoney * r.MaxDailyLossPercent / 100; byPercent > 0 && (limit == 0 || byPercent < limit) {
+		limit = byPercent
+	}
+	return limit
+}
+
+// entryLimit returns why a new position can't be opened under the limits, "" if it can
+func (r RiskConfig) entryLimit(state StrategyState) string {
+	if limit := r.dailyLossLimit(state.StartMoney); limit > 0 && state.StartMoney-state.Stats.money >= limit {
+		return fmt.Sprintf("daily loss %.2f RUB reached the limit %.2f RUB", state.StartMoney-state.Stats.money, limit)
+	}
+	if r.MaxTrades > 0 && state.Stats.transactionCount >= r.MaxTrades {
+		return fmt.Sprintf("%v trades reached the limit of the day", state.Stats.transactionCount)
+	}
+	return ""
+}
+
+// buyQuantity returns how many lots a BUY orders with the money at the price and the confidence of the predictor,
+// and why it is 0: there is no money for a lot or the sized part of the money doesn't buy one
+func (r RiskConfig) buyQuantity(money float64, price float64, confidence float64) (int64, string) {
+	fraction := r.PositionFraction
+	if fraction <= 0 {
+		fraction = 1
+	}
+	if r.ConfidenceSizing {
+		fraction *= confidence
+	}
+	// the price may change and I won't be able to buy needed amount of stocks (that's why -1)
+	affordable := int64(money/price) - 1
+	if affordable <= 0 {
+		return 0, "not enough money"
+	}
+	quantity := int64(money * fraction / price)
+	if quantity > affordable {
+		quantity = affordable
+	}
+	if r.MaxPositionLots > 0 && quantity > r.MaxPositionLots {
+		quantity = r.MaxPositionLots
+	}
+	if quantity <= 0 {
+		return 0, fmt.Sprintf("sizing leaves %.4f of the money, less than a lot", fraction)
+	}
+	return quantity, ""
+}
+
+var errNotArmed = errors.New("live mode is not armed")
+
+// checkArmed requires the id of the account in the -arm flag or in the arm file, so real money is never traded by mistake
+func checkArmed(accountId string, arm string, config LiveConfig) error {
+	if arm != "" {
+		if arm != accountId {
+			return fmt.Errorf("%w: -arm %q is not the account %v", errNotArmed, arm, accountId)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(config.ArmFile)
+	if err != nil {
+		return fmt.Errorf("%w: run with -arm <account id> or write the account id to %v", errNotArmed, config.ArmFile)
+	}
+	if strings.TrimSpace(string(data)) != accountId {
+		return fmt.Errorf("%w: %v doesn't contain the account %v", errNotArmed, config.ArmFile, accountId)
+	}
+	return nil
+}
+
+// prepareLive checks the real account before the bot trades on it: the account of the config (or the only open account
+// with full access if account_id is empty) must be open and the token must have full access to it, GetInfo must answer,
+// and the mode must be armed. The config with the resolved account id is returned.
+func prepareLive(configParams Config, arm string, logger investgo.Logger) (Config, error) {
+	if strings.Contains(configParams.targetAPI(), "sandbox") {
+		return configParams, fmt.Errorf("live mode can't use the sandbox endpoint %v", configParams.targetAPI())
+	}
+	client, closeClient := commandClient(configParams, commandLogger())
+	defer closeClient()
+	usersService := client.NewUsersServiceClient()
+
+	logger.Infof("Sent GetInfo request")
+	infoResp, err := usersService.GetInfo()
+	logger.Infof("Got response for GetInfo request")
+	if err != nil {
+		return configParams, fmt.Errorf("token check failed: %w", err)
+	}
+	logger.Infof("Token of the live mode: tariff = %v, qualified investor = %v, premium = %v", infoResp.GetTariff(), infoResp.GetQualStatus(), infoResp.GetPremStatus())
+	if tariff := configParams.Fees.Tariff; tariff != "" && infoResp.GetTariff() != "" && tariff != infoResp.GetTariff() {
+		logger.Infof("Tariff of the fee model %q differs from the tariff of the account %q, the commissions will be estimated wrong", tariff, infoResp.GetTariff())
+	}
+
+	logger.Infof("Sent GetAccounts request")
+	accountsResp, err := usersService.GetAccounts()
+	logger.Infof("Got response for GetAccounts request")
+	if err != nil {
+		return configParams, fmt.Errorf("can't get accounts: %w", err)
+	}
+	var account *pb.Account
+	if configParams.AccountID != "" {
+		for _, acc := range accountsResp.GetAccounts() {
+			if acc.GetId() == configParams.AccountID {
+				account = acc
+			}
+		}
+		if account == nil {
+			return configParams, fmt.Errorf("account %v is not found among the accounts of the token", configParams.AccountID)
+		}
+	} else {
+		var candidates []*pb.Account
+		for _, acc := range accountsResp.GetAccounts() {
+			if acc.GetStatus() == pb.AccountStatus_ACCOUNT_STATUS_OPEN && acc.GetAccessLevel() == pb.AccessLevel_ACCOUNT_ACCESS_LEVEL_FULL_ACCESS {
+				candidates = append(candidates, acc)
+			}
+		}
+		if len(candidates) != 1 {
+			return configParams, fmt.Errorf("account_id is not set and the token has %v open accounts with full access, see accounts list", len(candidates))
+		}
+		account = candidates[0]
+		configParams.AccountID = account.GetId()
+	}
+	if account.GetStatus() != pb.AccountStatus_ACCOUNT_STATUS_OPEN {
+		return configParams, fmt.Errorf("account %v is not open: %v", account.GetId(), account.GetStatus().String())
+	}
+	switch account.GetAccessLevel() {
+	case pb.AccessLevel_ACCOUNT_ACCESS_LEVEL_FULL_ACCESS:
+	case pb.AccessLevel_ACCOUNT_ACCESS_LEVEL_READ_ONLY:
+		return configParams, fmt.Errorf("token is read-only for the account %v, orders can't be sent", account.GetId())
+	default:
+		return configParams, fmt.Errorf("token has no access to the account %v: %v", account.GetId(), account.GetAccessLevel().String())
+	}
+	logger.Infof("Live account %v (%v) is verified", account.GetId(), account.GetName())
+
+	err = checkArmed(configParams.AccountID, arm, configParams.liveConfig())
+	if err != nil {
+		return configParams, err
+	}
+	return configParams, nil
+}
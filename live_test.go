@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestBuyQuantity(t *testing.T) {
+	tests := []struct {
+		name       string
+		risk       RiskConfig
+		money      float64
+		confidence float64
+		want       int64
+		skipped    bool
+	}{
+		{name: "all the money keeps a lot in reserve", money: 10000, want: 99},
+		{name: "not enough money for a lot and the reserve", money: 150, skipped: true},
+		{name: "a fraction of the money", risk: RiskConfig{PositionFraction: 0.5}, money: 10000, want: 50},
+		{name: "the reserve caps a big fraction", risk: RiskConfig{PositionFraction: 0.995}, money: 10000, want: 99},
+		{name: "a small fraction still buys a lot", risk: RiskConfig{PositionFraction: 0.01}, money: 15000, want: 1},
+		{name: "confidence scales the fraction", risk: RiskConfig{PositionFraction: 0.5, ConfidenceSizing: true}, money: 10000, confidence: 0.6, want: 30},
+		{name: "sizing leaves less than a lot", risk: RiskConfig{PositionFraction: 0.5, ConfidenceSizing: true}, money: 10000, confidence: 0.01, skipped: true},
+		{name: "max position lots", risk: RiskConfig{MaxPositionLots: 20}, money: 10000, want: 20},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, reason := test.risk.buyQuantity(test.money, 100, test.confidence)
+			if got != test.want || (reason != "") != test.skipped {
+				t.Errorf("buyQuantity = %v, %q, want %v (skipped %v)", got, reason, test.want, test.skipped)
+			}
+		})
+	}
+}
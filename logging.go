@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoggingConfig configures the log of the main loop, the log of the strategy and the structured trade-event stream
+type LoggingConfig struct {
+	Main     LogConfig `yaml:"main"`
+	Strategy LogConfig `yaml:"strategy"`
+	Events   LogConfig `yaml:"events"`
+}
+
+// LogConfig describes one log. A new file is started every day and when the file grows over MaxSizeMB,
+// files older than MaxAgeDays are deleted and at most MaxFiles newest files are kept (0 - no limit).
+// Encoding is "console" (default) or "json" for production.
+type LogConfig struct {
+	Dir           string `yaml:"dir"`
+	Encoding      string `yaml:"encoding"`
+	Level         string `yaml:"level"`
+	MaxSizeMB     int    `yaml:"max_size_mb"`
+	MaxAgeDays    int    `yaml:"max_age_days"`
+	MaxFiles      int    `yaml:"max_files"`
+	DisableStderr bool   `yaml:"disable_stderr"`
+}
+
+const (
+	logEncodingConsole = "console"
+	logEncodingJSON    = "json"
+)
+
+func (c LogConfig) withDefaults() LogConfig {
+	if c.Dir == "" {
+		c.Dir = "./logs"
+	}
+	if c.Encoding == "" {
+		c.Encoding = logEncodingConsole
+	}
+	if c.Level == "" {
+		c.Level = "debug"
+	}
+	if c.MaxSizeMB <= 0 {
+		c.MaxSizeMB = 100
+	}
+	if c.MaxAgeDays <= 0 {
+		c.MaxAgeDays = 30
+	}
+	return c
+}
+
+// rotatingFile writes to <dir>/<YYYY-MM-DD>_<name>.log and switches to a new file at midnight or when maxSize is reached,
+// the files of the same day are numbered: <YYYY-MM-DD>_<name>.1.log, .2.log, ...
+type rotatingFile struct {
+	mu       sync.Mutex
+	dir      string
+	name     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	file     *os.File
+	date     string
+	index    int
+	size     int64
+}
+
+func newRotatingFile(dir string, name string, config LogConfig) (*rotatingFile, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFile{
+		dir:      dir,
+		name:     name,
+		maxSize:  int64(config.MaxSizeMB) * 1024 * 1024,
+		maxAge:   time.Duration(config.MaxAgeDays) * 24 * time.Hour,
+		maxFiles: config.MaxFiles,
+	}, nil
+}
+
+func (r *rotatingFile) fileName(date string, index int) string {
+	if index == 0 {
+		return filepath.Join(r.dir, fmt.Sprintf("%s_%s.log", date, r.name))
+	}
+	return filepath.Join(r.dir, fmt.Sprintf("%s_%s.%d.log", date, r.name, index))
+}
+
+// open continues the last file of the day after a restart
+func (r *rotatingFile) open(date string, index int) error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	if index == 0 {
+		for {
+			if _, err := os.Stat(r.fileName(date, index+1)); err != nil {
+				break
+			}
+			index++
+		}
+	}
+	file, err := os.OpenFile(r.fileName(date, index), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.date, r.index, r.size = file, date, index, info.Size()
+	r.removeOld()
+	return nil
+}
+
+// removeOld deletes the files of the log that are older than maxAge or beyond maxFiles
+func (r *rotatingFile) removeOld() {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*_"+r.name+"*.log"))
+	if err != nil {
+		return
+	}
+	type logFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []logFile
+	for _, path := range paths {
+		// "<date>_stats" must not match "<date>_tradeStats" and the other way round
+		base := strings.TrimSuffix(filepath.Base(path), ".log")
+		if i := strings.Index(base, "_"); i < 0 || strings.SplitN(base[i+1:], ".", 2)[0] != r.name {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{path: path, modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	current := r.fileName(r.date, r.index)
+	for i, f := range files {
+		if f.path == current {
+			continue
+		}
+		if time.Since(f.modTime) > r.maxAge || (r.maxFiles > 0 && i >= r.maxFiles) {
+			os.Remove(f.path)
+		}
+	}
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	date := time.Now().Format("2006-01-02")
+	var err error
+	if r.file == nil || date != r.date {
+		err = r.open(date, 0)
+	} else if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err = r.open(date, r.index+1)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// newLogger builds the logger writing to the rotating files <dir>/<YYYY-MM-DD>_<accountId>_<name>.log and to stderr
+func newLogger(config LogConfig, accountId string, name string) (*zap.Logger, error) {
+	config = config.withDefaults()
+	level, err := zapcore.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	var encoder zapcore.Encoder
+	switch config.Encoding {
+	case logEncodingJSON:
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderConfig.TimeKey = "time"
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case logEncodingConsole:
+		encoderConfig := zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05")
+		encoderConfig.TimeKey = "time"
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("unknown log encoding %q", config.Encoding)
+	}
+	file, err := newRotatingFile(config.Dir, fmt.Sprintf("%s_%s", accountId, name), config)
+	if err != nil {
+		return nil, err
+	}
+	output := zapcore.WriteSyncer(file)
+	if !config.DisableStderr {
+		output = zapcore.NewMultiWriteSyncer(file, zapcore.Lock(os.Stderr))
+	}
+	return zap.New(zapcore.NewCore(encoder, output, level), zap.AddCaller()), nil
+}
+
+// newEventLogger builds the trade-event stream: every journal record as one JSON line, without stderr
+func newEventLogger(config LogConfig, accountId string) (*zap.Logger, error) {
+	config.Encoding = logEncodingJSON
+	config.DisableStderr = true
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	return newLogger(config, accountId, "events")
+}
+
+// newCommandLogger is the logger of the one-shot commands: console lines on stderr only, so stdout stays for the output
+func newCommandLogger(level string) (*zap.Logger, error) {
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05")
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), zapcore.Lock(os.Stderr), parsed)), nil
+}
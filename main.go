@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/russianinvestments/invest-api-go-sdk/investgo"
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"log"
 	"os"
 	"os/signal"
@@ -14,26 +13,78 @@ import (
 	"time"
 )
 
-// развернуть на стенде, пуш на гитхаб (+версионность, тэги), добавить реальный счет и деньги, левередж транзакций, смотреть чтобы файлы логов не переполнялись
+// развернуть на стенде, пуш на гитхаб (+версионность, тэги), добавить реальный счет и деньги, левередж транзакций
 // нужен другой токен? метрика живости стенда
 // used with v1 api
 
+// predictorOutageFailures failed requests in a row are reported as a predictor outage
+const predictorOutageFailures = 3
+
 // start script:
 // go build (-o <executable name>)
-// ./TradingBot -config <path to config file>
+// ./TradingBot [run] -config <path to config file>
+// ./TradingBot help - all commands, see cli.go
 func main() {
+	runCLI(os.Args[1:])
+}
+
+// runTradeCommand trades in the mode of the config, the live mode has to be armed:
+// ./TradingBot run -config <path to config file> [-account <id>] [-arm <account id>]
+func runTradeCommand(args []string) {
+	flags, configFilePath := commandFlags("run")
+	accountId := flags.String("account", "", "account to trade on, account_id of the config by default")
+	arm := flags.String("arm", "", "id of the account, confirms trading with real money in the live mode")
+	_ = flags.Parse(args)
+	configParams := readConfig(*configFilePath)
+	if *accountId != "" {
+		configParams.AccountID = *accountId
+	}
+	if configParams.mode() == modeLive {
+		var err error
+		configParams, err = prepareLive(configParams, *arm, commandLogger())
+		if err != nil {
+			log.Fatalf("Live mode refused to start: %v", err)
+		}
+	}
+	trade(configParams)
+}
+
+// runPaperCommand is run in the paper mode whatever the mode of the config is:
+// ./TradingBot paper -config <path to config file> [-money 205000]
+func runPaperCommand(args []string) {
+	flags, configFilePath := commandFlags("paper")
+	money := flags.Float64("money", 0, "start money of the simulated account, RUB, paper_money of the config by default")
+	_ = flags.Parse(args)
+	configParams := readModeConfig(*configFilePath, modePaper)
+	if *money > 0 {
+		configParams.PaperMoney = *money
+	}
+	trade(configParams)
+}
+
+// trade runs the trading loop: a candle is taken every minute, sent to the predictor and the action is passed to the strategy.
+// In the paper mode orders never reach the exchange, they are filled by a simulatedBroker at the candle close,
+// the journal, logs and reports are written for the account "paper_<account_id>".
+func trade(configParams Config) {
 	var requestCounter uint64
-	configParams := getConfigParams()
+	paper := configParams.mode() == modePaper
+	accountId := configParams.AccountID
+	if paper {
+		accountId = "paper_" + configParams.AccountID
+	}
 
-	zapConfig := zap.NewDevelopmentConfig()
-	zapConfig.OutputPaths = []string{fmt.Sprintf("./logs/%s_%s_stats.log", time.Now().Format("2006_January_02"), configParams.AccountID), "stderr"}
-	zapConfig.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(time.DateTime)
-	zapConfig.EncoderConfig.TimeKey = "time"
-	l, err := zapConfig.Build()
+	// the log files are rotated every day and by size, so a multi-day run doesn't write into one file
+	l, err := newLogger(configParams.Logging.Main, accountId, "stats")
 	if err != nil {
 		log.Fatalf("logger creating error %v", err)
 	}
 	logger := l.Sugar()
+	strategyLogger := getNewLogger(configParams.Logging.Strategy, accountId)
+	eventLogger, err := newEventLogger(configParams.Logging.Events, accountId)
+	if err != nil {
+		log.Fatalf("logger creating error %v", err)
+	}
+	defer eventLogger.Sync()
 	defer func() {
 		err := logger.Sync()
 		if err != nil {
@@ -41,22 +92,10 @@ func main() {
 		}
 	}()
 
-	config := investgo.Config{
-		EndPoint:                      configParams.TargetAPI + ":443",
-		Token:                         configParams.Token,
-		AppName:                       "invest-api-go-sdk",
-		AccountId:                     configParams.AccountID,
-		DisableResourceExhaustedRetry: false,
-		DisableAllRetry:               false,
-		MaxRetries:                    3,
-	}
-
-	requestURL := fmt.Sprintf("http://localhost:%v/data", configParams.Port)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	client, err := investgo.NewClient(ctx, config, logger)
+	client, err := investgo.NewClient(ctx, investConfig(configParams), logger)
 	if err != nil {
 		logger.Fatalf("client creating error %v", err.Error())
 	}
@@ -68,29 +107,65 @@ func main() {
 		}
 	}()
 
-	// сервис песочницы нужен лишь для управления счетами песочницы и пополнения баланса
-	// остальной функционал доступен через обычные сервисы, но с эндпоинтом песочницы
-	//sandboxService := client.NewSandboxServiceClient()
-
-	//accId, err := createAccountId(client, sandboxService, logger)
-	//fmt.Println("created new account!, id:", accId)
-
+	// счета песочницы открываются и пополняются командами sandbox open и sandbox deposit
 	// TODO: to work with real money I need a real account
-	//accId, err := getAccountId(client, sandboxService, logger)
-	//if err != nil {
-	//	return
-	//}
 
-	// пополняем счет песочницы на 205 000 рублей
-	//depositMoney(sandboxService, client.Config.AccountId, 205000, logger)
+	journal, err := openJournal(configParams.journalDir(), accountId)
+	if err != nil {
+		logger.Fatalf("journal opening error %v", err.Error())
+	}
+	defer journal.Close()
+	journal.setEventLogger(eventLogger)
+
+	botHealth.configure(configParams.Health, fmt.Sprintf("localhost:%v", configParams.Port))
+	monitoringServer := startHTTPServer(configParams.httpAddr(), logger)
+	defer monitoringServer.Close()
+	notifier := newNotifier(configParams.Notifications, logger)
+	defer notifier.Close(10 * time.Second)
+	predictors := newPredictors(configParams, botMetrics, logger)
+	admin := newAdmin(predictors)
+	if adminServer := startAdminServer(configParams.adminConfig(), admin, logger); adminServer != nil {
+		defer adminServer.Close()
+	}
 
 	// TCS - same but in dollars
 	id_TCSG, err := getInstrumentId(client, logger, "TCSG")
+	botHealth.setBroker(err)
 	if err != nil {
 		return
 	}
+	botHealth.setInstrument(id_TCSG)
+
+	// the orders of paper trading are filled at the last candle, the checkpoint would restore a state the simulated account doesn't have
+	var (
+		broker         Broker
+		simulated      *simulatedBroker
+		checkpointPath string
+	)
+	if paper {
+		simulated = newSimulatedBroker(configParams.paperMoney(), configParams.feeConfig(), configParams.fillModelConfig(), time.Minute, false, journal, strategyLogger)
+		simulated.metrics = botMetrics
+		broker = simulated
+	} else {
+		broker = newLiveBroker(client, client.Config.AccountId, configParams.executionFor("TCSG"), configParams.brokerFeeConfig(), journal, strategyLogger)
+		checkpointPath = checkpointFileName(configParams.checkpointDir(), accountId)
+	}
 
-	interruptSignalChan := make(chan os.Signal)
+	// the session file keeps everything the strategy got and did, "replay" reproduces its decisions from it
+	var recorder *SessionRecorder
+	if recording := configParams.recordingConfig(); recording.Enabled {
+		recorder, err = openSessionRecorder(recording.Dir, accountId, logger)
+		if err != nil {
+			logger.Fatalf("session recorder opening error %v", err.Error())
+		}
+		defer recorder.Close()
+		logger.Infof("Recording the session to %v", recorder.path)
+		fees, risk := configParams.feeConfig(), configParams.riskConfig()
+		recorder.record(SessionEvent{Kind: sessionStart, AccountID: accountId, InstrumentID: id_TCSG, Fees: &fees, Risk: &risk})
+		broker = newRecordingBroker(broker, recorder)
+	}
+
+	interruptSignalChan := make(chan os.Signal, 1)
 	signal.Notify(interruptSignalChan, os.Interrupt, syscall.SIGTERM)
 
 	// change to 1 Minute
@@ -98,11 +173,39 @@ func main() {
 	defer ticker.Stop()
 
 	var wg sync.WaitGroup
-	actions := make(chan int, 10)
+	actions := make(chan Signal, 10)
 	wg.Add(1)
 	go func() {
 		// default - true
 		exchangeClosed := true
+		predictorFailures := 0
+		// the candles of the session are checked before they reach the predictor, the report is written at the close
+		quality := configParams.dataQualityConfig()
+		var validator *CandleValidator
+		// the shadow predictors get the same candles, their actions are compared with the primary ones at the close
+		shadows := make(map[string]Predictor, len(configParams.Shadows))
+		for _, name := range configParams.Shadows {
+			shadows[name] = predictors[name]
+		}
+		var shadowWg sync.WaitGroup
+		finishSession := func() {
+			if validator != nil {
+				finishQualityReport(quality.Dir, validator.report, logger)
+				validator = nil
+				if len(shadows) > 0 {
+					if !waitShadowPredictors(&shadowWg, shadowWaitTimeout) {
+						logger.Errorf("Shadow predictors didn't answer in %v, the report misses their last actions", shadowWaitTimeout)
+					}
+					path, results, err := generateShadowReport(configParams, accountId, time.Now())
+					if err != nil {
+						logger.Errorf("Can't write the shadow report: %v", err.Error())
+					} else {
+						logShadowResults(results, logger)
+						logger.Infof("Shadow report => %v", path)
+					}
+				}
+			}
+		}
 		defer wg.Done()
 		defer func() {
 			if r := recover(); r != nil {
@@ -115,40 +218,103 @@ func main() {
 			select {
 			case <-interruptSignalChan:
 				logger.Infof("Caught interrupt signal: Close actions channel")
+				finishSession()
 				close(actions)
 				return
 			case <-ticker.C:
+				botHealth.tick()
 				// 0 - Sunday, 6 - Saturday
-				if weekday, hour, minute := getMoscowTime(); !(weekday != 0 && weekday != 6 && hour*60+minute > openMoscowHour*60+openMoscowMinute && hour*60+minute < closeMoscowHour*60+closeMoscowMinute) {
+				weekday, hour, minute := getMoscowTime()
+				exchangeOpen := weekday != 0 && weekday != 6 && hour*60+minute > openMoscowHour*60+openMoscowMinute && hour*60+minute < closeMoscowHour*60+closeMoscowMinute
+				botHealth.setSession(exchangeOpen)
+				if !exchangeOpen {
 					if !exchangeClosed {
 						// TODO: after we get the message in logs that exchange is closed for today and press ctrl c - the program does not stop! check it
 						logger.Infof("Exchange is closed for today.")
-						actions <- 4
+						actions <- Signal{Action: 4}
 						exchangeClosed = true
+						finishSession()
 					}
 					break
 				}
 				if exchangeClosed {
 					logger.Infof("Exchange is open now.")
 					wg.Add(1)
-					go startStrategy(actions, client, client.Config.AccountId, id_TCSG, &wg)
+					go startStrategy(actions, strategyParams{
+						broker:         broker,
+						accountId:      accountId,
+						instrumentId:   id_TCSG,
+						journal:        journal,
+						checkpointPath: checkpointPath,
+						reconciliation: configParams.reconciliationConfig(),
+						fees:           configParams.feeConfig(),
+						risk:           configParams.riskConfig(),
+						reportsDir:     configParams.reportsDir(),
+						brokerReport:   configParams.brokerReportConfig(),
+						historyDir:     configParams.historyDir(),
+						benchmark:      configParams.benchmarkConfig(),
+						admin:          admin,
+						notifier:       notifier,
+						recorder:       recorder,
+						metrics:        botMetrics,
+						logger:         strategyLogger,
+					}, &wg)
 					exchangeClosed = false
+					validator = newCandleValidator(fmt.Sprintf("%s_%s_TCSG", time.Now().Format("2006-01-02"), accountId), time.Minute, quality)
 				}
+				botMetrics.inc(metricTicks)
 				request, err := getLastPriceAndVolume(client, id_TCSG, &requestCounter, logger)
+				if errors.Is(err, errNoCandles) {
+					botHealth.setBroker(nil)
+				} else {
+					botHealth.setBroker(err)
+				}
 				if err != nil {
+					recorder.record(SessionEvent{Kind: sessionCandle, InstrumentID: id_TCSG, Error: err.Error()})
 					logger.Infof("Skipped one cycle stage")
+					botMetrics.inc(metricSkippedCycles, "reason", "market_data")
+					continue
+				}
+				recorder.record(SessionEvent{Kind: sessionCandle, InstrumentID: id_TCSG, Candle: &request})
+				if !validator.check(request) {
+					issue := validator.report.Issues[len(validator.report.Issues)-1]
+					logger.Infof("Candle of %v is quarantined, %v: %v", request.Datetime, issue.Kind, issue.Detail)
+					botMetrics.inc(metricSkippedCycles, "reason", "data_quality")
 					continue
 				}
+				botMetrics.setCandle(id_TCSG, request.Datetime, request.Close)
+				if simulated != nil {
+					simulated.onCandle(id_TCSG, request)
+				}
 				logger.Infof("Got price and volume from exchange! Volume = %v and price = %v\n", request.Volume, request.Close)
 
 				// TODO: should check the request/response ids!
-				response, err := send_request(request, requestURL, &requestCounter, logger)
+				for name, predictor := range shadows {
+					shadowWg.Add(1)
+					go callShadowPredictor(name, predictor, request, id_TCSG, journal, logger, &shadowWg)
+				}
+				requestStart := time.Now()
+				recorder.record(SessionEvent{Kind: sessionPredictorRequest, Candle: &request})
+				response, err := admin.activePredictor().predict(request)
+				botMetrics.observe(metricPredictorLatency, time.Since(requestStart).Seconds())
 				if err != nil {
+					recorder.record(SessionEvent{Kind: sessionPredictorResponse, Error: err.Error()})
 					logger.Errorf("Error happened on the Python server side")
+					botMetrics.inc(metricSkippedCycles, "reason", "predictor")
+					predictorFailures++
+					if predictorFailures == predictorOutageFailures {
+						notifier.notify(eventPredictorOutage, "Predictor failed %v times in a row, last error: %v", predictorFailures, err.Error())
+					}
 					continue
 				}
+				if predictorFailures >= predictorOutageFailures {
+					notifier.notify(eventPredictorOutage, "Predictor is back after %v failed requests", predictorFailures)
+				}
+				predictorFailures = 0
+				recorder.record(SessionEvent{Kind: sessionPredictorResponse, Response: &response})
+				journal.record(JournalRecord{Kind: journalSignal, InstrumentID: id_TCSG, ReqID: request.ReqId, Action: response.Action, Confidence: response.confidence(), ModelVersion: response.ModelVersion, Price: request.Close, Candle: &request})
 
-				actions <- response.Action
+				actions <- response.signal()
 			}
 		}
 	}()
@@ -156,10 +322,6 @@ func main() {
 }
 
 func getMoscowTime() (int, int, int) {
-	local := time.Now().UTC()
-	location, err := time.LoadLocation("Europe/Moscow")
-	if err == nil {
-		local = local.In(location)
-	}
+	local := time.Now().In(moscowLocation())
 	return int(local.Weekday()), local.Hour(), local.Minute()
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of the bot, they are exported in the Prometheus text format on /metrics
+const (
+	metricTicks            = "tradingbot_ticks_total"
+	metricSkippedCycles    = "tradingbot_skipped_cycles_total"
+	metricPredictorLatency = "tradingbot_predictor_latency_seconds"
+	metricPredictorErrors  = "tradingbot_predictor_errors_total"
+	metricOrders           = "tradingbot_order_states_total"
+	metricFillSlippage     = "tradingbot_fill_slippage_bps"
+	metricPosition         = "tradingbot_position_lots"
+	metricCash             = "tradingbot_cash_rub"
+	metricRealizedPnL      = "tradingbot_realized_pnl_rub"
+	metricUnrealizedPnL    = "tradingbot_unrealized_pnl_rub"
+	metricLastCandleAge    = "tradingbot_last_candle_age_seconds"
+)
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+type metricSeries struct {
+	labels       string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	// value is called on every scrape for the gauges that are calculated, not set
+	value  func() (float64, bool)
+	series map[string]*metricSeries
+}
+
+// Metrics is a small registry of counters, gauges and histograms, safe for concurrent use.
+// A nil *Metrics records nothing, backtests update no metrics
+type Metrics struct {
+	mu         sync.Mutex
+	families   []*metricFamily
+	byName     map[string]*metricFamily
+	lastPrices map[string]float64
+	lastCandle time.Time
+}
+
+// botMetrics is shared by the main loop, the strategy goroutine and the order executor
+var botMetrics = newBotMetrics()
+
+func newMetrics() *Metrics {
+	return &Metrics{byName: map[string]*metricFamily{}, lastPrices: map[string]float64{}}
+}
+
+func newBotMetrics() *Metrics {
+	m := newMetrics()
+	m.register(metricTicks, "Market data ticks processed during the trading session.", metricCounter, nil)
+	m.register(metricSkippedCycles, "Ticks skipped because of a failed stage (reason = market_data, data_quality, predictor).", metricCounter, nil)
+	m.register(metricPredictorLatency, "Latency of the predictor requests.", metricHistogram, []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	m.register(metricPredictorErrors, "Failed predictor requests by predictor and error type.", metricCounter, nil)
+	m.register(metricOrders, "Order states reported by the exchange by direction and status, ERROR if the request failed.", metricCounter, nil)
+	m.register(metricFillSlippage, "Fill price against the last candle close in basis points, positive is worse for the bot.", metricHistogram, []float64{-50, -20, -10, -5, 0, 5, 10, 20, 50, 100})
+	m.register(metricPosition, "Lots of the traded instrument held by the strategy.", metricGauge, nil)
+	m.register(metricCash, "Money of the strategy in RUB.", metricGauge, nil)
+	m.register(metricRealizedPnL, "Realized profit of the day after fees in RUB.", metricGauge, nil)
+	m.register(metricUnrealizedPnL, "Profit of the open position at the last candle close in RUB.", metricGauge, nil)
+	m.register(metricLastCandleAge, "Seconds since the last received candle.", metricGauge, nil)
+	m.byName[metricLastCandleAge].value = func() (float64, bool) {
+		if m.lastCandle.IsZero() {
+			return 0, false
+		}
+		return time.Since(m.lastCandle).Seconds(), true
+	}
+	return m
+}
+
+func (m *Metrics) register(name string, help string, kind string, buckets []float64) {
+	family := &metricFamily{name: name, help: help, kind: kind, buckets: buckets, series: map[string]*metricSeries{}}
+	m.families = append(m.families, family)
+	m.byName[name] = family
+}
+
+// formatLabels renders label pairs ("status", "FILL", ...) as {status="FILL",...}
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *Metrics) getSeries(name string, labels []string) *metricSeries {
+	family := m.byName[name]
+	key := formatLabels(labels)
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labels: key, bucketCounts: make([]uint64, len(family.buckets))}
+		family.series[key] = series
+	}
+	return series
+}
+
+// add increases the counter by value, labels are name/value pairs
+func (m *Metrics) add(name string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSeries(name, labels).value += value
+}
+
+func (m *Metrics) inc(name string, labels ...string) {
+	m.add(name, 1, labels...)
+}
+
+func (m *Metrics) set(name string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSeries(name, labels).value = value
+}
+
+func (m *Metrics) observe(name string, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	family := m.byName[name]
+	series := m.getSeries(name, labels)
+	for i, bound := range family.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+		}
+	}
+	series.value += value
+	series.count++
+}
+
+// setCandle remembers the last candle of the instrument for the slippage, P&L and candle age metrics
+func (m *Metrics) setCandle(instrumentId string, candleTime time.Time, close float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastPrices[instrumentId] = close
+	m.lastCandle = candleTime
+}
+
+func (m *Metrics) lastPrice(instrumentId string) float64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPrices[instrumentId]
+}
+
+// observeFill records the slippage of the fill against the last candle close
+func (m *Metrics) observeFill(instrumentId string, direction string, lots int64, amount float64) {
+	reference := m.lastPrice(instrumentId)
+	if reference == 0 || lots == 0 {
+		return
+	}
+	slippage := (amount/float64(lots) - reference) / reference * 10000
+	if direction == directionSell {
+		slippage = -slippage
+	}
+	m.observe(metricFillSlippage, slippage, "direction", direction)
+}
+
+// setStrategyState updates position, cash and P&L gauges from the strategy state
+func (m *Metrics) setStrategyState(state StrategyState) {
+	if m == nil {
+		return
+	}
+	stats := state.Stats
+	lastPrice := m.lastPrice(state.InstrumentID)
+	m.set(metricPosition, float64(state.ShareNumber))
+	m.set(metricCash, stats.money)
+	// bought lots are valued at the buy point, so the realized profit doesn't change until the position is sold
+	m.set(metricRealizedPnL, stats.money+float64(state.ShareNumber)*stats.buyPoint-state.StartMoney)
+	unrealized := 0.0
+	if state.ShareNumber > 0 && stats.buyPoint > 0 && lastPrice > 0 {
+		unrealized = float64(state.ShareNumber) * (lastPrice - stats.buyPoint)
+	}
+	m.set(metricUnrealizedPnL, unrealized)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// write writes all metrics in the Prometheus text exposition format
+func (m *Metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	for _, family := range m.families {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		if family.value != nil {
+			if value, ok := family.value(); ok {
+				fmt.Fprintf(&b, "%s %s\n", family.name, formatMetricValue(value))
+			}
+			continue
+		}
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != metricHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", family.name, series.labels, formatMetricValue(series.value))
+				continue
+			}
+			for i, bound := range family.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, bucketLabels(series.labels, formatMetricValue(bound)), series.bucketCounts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, bucketLabels(series.labels, "+Inf"), series.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", family.name, series.labels, formatMetricValue(series.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", family.name, series.labels, series.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func bucketLabels(labels string, bound string) string {
+	le := fmt.Sprintf("le=\"%s\"", bound)
+	if labels == "" {
+		return "{" + le + "}"
+	}
+	return labels[:len(labels)-1] + "," + le + "}"
+}
+
+func (m *Metrics) handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.write(w)
+}
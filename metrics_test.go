@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		want   string
+	}{
+		{name: "no labels"},
+		{name: "plain values", labels: []string{"direction", "BUY", "status", "FILL"}, want: `{direction="BUY",status="FILL"}`},
+		{name: "escaped once", labels: []string{"reason", "a \"quoted\" \\ value\nnext"}, want: `{reason="a \"quoted\" \\ value\nnext"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := formatLabels(test.labels); got != test.want {
+				t.Errorf("formatLabels = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSimulatedBrokerMetrics(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	candle := RequestToPredict{Datetime: time.Date(2024, 3, 1, 10, 0, 0, 0, moscowLocation()), Open: 100, High: 100, Low: 100, Close: 100, Volume: 10000}
+
+	// a backtest broker has no metrics
+	backtest := newSimulatedBroker(100000, FeeConfig{}, FillModelConfig{Price: fillPriceClose}, time.Minute, true, nil, logger)
+	backtest.onCandle("TCSG", candle)
+	if _, err := backtest.buy("TCSG", 10); err != nil {
+		t.Fatalf("backtest buy: %v", err)
+	}
+
+	paper := newSimulatedBroker(100000, FeeConfig{}, FillModelConfig{Price: fillPriceClose}, time.Minute, false, nil, logger)
+	paper.metrics = newBotMetrics()
+	paper.onCandle("TCSG", candle)
+	if _, err := paper.buy("TCSG", 10); err != nil {
+		t.Fatalf("paper buy: %v", err)
+	}
+	var b strings.Builder
+	if err := paper.metrics.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%s{direction=\"BUY\",status=\"%s\"} 1", metricOrders, pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL); !strings.Contains(b.String(), want) {
+		t.Errorf("paper metrics have no %v:\n%v", want, b.String())
+	}
+}
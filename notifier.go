@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events the bot notifies about
+const (
+	eventFill              = "fill"
+	eventDailySummary      = "daily_summary"
+	eventRiskLimit         = "risk_limit"
+	eventLiquidationFailed = "liquidation_failed"
+	eventPredictorOutage   = "predictor_outage"
+)
+
+// NotifierConfig describes the notification backends and which events go where.
+// Routes map an event to backend names ("telegram" or a webhook name), the "default" route is used for the events not listed,
+// without routes every event goes to every backend. Each backend sends at most MaxPerMinute messages.
+type NotifierConfig struct {
+	Telegram     TelegramConfig           `yaml:"telegram"`
+	Webhooks     map[string]WebhookConfig `yaml:"webhooks"`
+	Routes       map[string][]string      `yaml:"routes"`
+	MaxPerMinute int                      `yaml:"max_per_minute"`
+}
+
+// TelegramConfig is the Telegram Bot API backend, BaseURL can point to a local stand-in for testing
+type TelegramConfig struct {
+	BaseURL string `yaml:"base_url"`
+	Token   string `yaml:"token"`
+	ChatID  string `yaml:"chat_id"`
+}
+
+// WebhookConfig is a generic backend: the notification is POSTed as JSON to URL with the extra headers
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type notification struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Text  string    `json:"text"`
+}
+
+type notifierBackend interface {
+	send(n notification) error
+}
+
+type telegramBackend struct {
+	config TelegramConfig
+	client *http.Client
+}
+
+func (b telegramBackend) send(n notification) error {
+	body, err := json.Marshal(map[string]string{"chat_id": b.config.ChatID, "text": fmt.Sprintf("[%s] %s", n.Event, n.Text)})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimSuffix(b.config.BaseURL, "/"), b.config.Token)
+	return postJSON(b.client, url, nil, body)
+}
+
+type webhookBackend struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+func (b webhookBackend) send(n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return postJSON(b.client, b.config.URL, b.config.Headers, body)
+}
+
+func postJSON(client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v: %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket refilled with perMinute tokens per minute
+type rateLimiter struct {
+	perMinute float64
+	tokens    float64
+	last      time.Time
+	dropped   int
+}
+
+func (r *rateLimiter) allow(now time.Time) bool {
+	if r.last.IsZero() {
+		r.tokens = r.perMinute
+	} else {
+		r.tokens += now.Sub(r.last).Minutes() * r.perMinute
+		if r.tokens > r.perMinute {
+			r.tokens = r.perMinute
+		}
+	}
+	r.last = now
+	if r.tokens < 1 {
+		r.dropped++
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Notifier sends notifications in the background, so a slow backend never delays trading.
+// All methods can be called on nil, then nothing is sent.
+type Notifier struct {
+	backends map[string]notifierBackend
+	limiters map[string]*rateLimiter
+	routes   map[string][]string
+	queue    chan notification
+	done     chan struct{}
+	logger   investgo.Logger
+	mu       sync.Mutex
+	closed   bool
+}
+
+func newNotifier(config NotifierConfig, logger investgo.Logger) *Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	backends := map[string]notifierBackend{}
+	if config.Telegram.Token != "" {
+		if config.Telegram.BaseURL == "" {
+			config.Telegram.BaseURL = "https://api.telegram.org"
+		}
+		backends["telegram"] = telegramBackend{config: config.Telegram, client: client}
+	}
+	for name, webhook := range config.Webhooks {
+		backends[name] = webhookBackend{config: webhook, client: client}
+	}
+	if len(backends) == 0 {
+		return nil
+	}
+	if config.MaxPerMinute <= 0 {
+		config.MaxPerMinute = 20
+	}
+	n := &Notifier{
+		backends: backends,
+		limiters: map[string]*rateLimiter{},
+		routes:   config.Routes,
+		queue:    make(chan notification, 100),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+	for name := range backends {
+		n.limiters[name] = &rateLimiter{perMinute: float64(config.MaxPerMinute)}
+	}
+	go n.run()
+	return n
+}
+
+// route returns the backends of the event
+func (n *Notifier) route(event string) []string {
+	names, ok := n.routes[event]
+	if !ok {
+		names, ok = n.routes["default"]
+	}
+	if !ok {
+		for name := range n.backends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return names
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for message := range n.queue {
+		for _, name := range n.route(message.Event) {
+			backend, ok := n.backends[name]
+			if !ok {
+				n.logger.Errorf("Unknown notification backend %q in route of %v", name, message.Event)
+				continue
+			}
+			limiter := n.limiters[name]
+			if !limiter.allow(time.Now()) {
+				continue
+			}
+			sent := message
+			if limiter.dropped > 0 {
+				sent.Text = fmt.Sprintf("%s\n(%v notifications were dropped by the rate limit)", sent.Text, limiter.dropped)
+			}
+			err := backend.send(sent)
+			if err != nil {
+				n.logger.Errorf("Can't send %v notification to %v: %v", message.Event, name, err.Error())
+				continue
+			}
+			limiter.dropped = 0
+		}
+	}
+}
+
+// notify queues the notification, it is dropped if the queue is full
+func (n *Notifier) notify(event string, format string, args ...interface{}) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- notification{Event: event, Time: time.Now(), Text: fmt.Sprintf(format, args...)}:
+	default:
+		n.logger.Errorf("Notification queue is full, %v notification is dropped", event)
+	}
+}
+
+// Close sends the queued notifications and stops the notifier, it waits at most timeout
+func (n *Notifier) Close(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+	case <-time.After(timeout):
+	}
+}
+
+// runNotifyTestCommand sends a test notification to every backend of the config:
+// ./TradingBot notify-test -config <path to config file> [-event fill]
+func runNotifyTestCommand(args []string) {
+	flags, configFilePath := commandFlags("notify-test")
+	event := flags.String("event", eventDailySummary, "event of the test notification")
+	_ = flags.Parse(args)
+
+	configParams := readConfig(*configFilePath)
+	notifier := newNotifier(configParams.Notifications, commandLogger())
+	if notifier == nil {
+		log.Fatalf("No notification backends in the config")
+	}
+	notifier.notify(*event, "Test notification of TradingBot, account %v", configParams.AccountID)
+	notifier.Close(30 * time.Second)
+}
+
+// redactBotToken hides the token of a Telegram Bot API path /bot<token>/sendMessage
+func redactBotToken(path string) string {
+	if !strings.HasPrefix(path, "/bot") {
+		return path
+	}
+	end := strings.Index(path[len("/bot"):], "/")
+	if end < 0 {
+		return "/bot<redacted>"
+	}
+	return "/bot<redacted>" + path[len("/bot")+end:]
+}
+
+// runNotifyStandInCommand runs a local stand-in of the Telegram Bot API and the webhooks: every request is printed
+// with the bot token redacted and answered with {"ok":true}. Point telegram.base_url and the webhook URLs to it:
+// ./TradingBot notify-standin -addr localhost:8090
+func runNotifyStandInCommand(args []string) {
+	flags := flag.NewFlagSet("notify-standin", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8090", "address to listen on")
+	_ = flags.Parse(args)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Printf("%v %v %v\n%s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, redactBotToken(r.URL.Path), body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+	log.Printf("Notification stand-in is listening on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// notificationServer stands in for the Telegram Bot API and the webhooks and records every request
+type notificationServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []receivedRequest
+}
+
+func newNotificationServer() *notificationServer {
+	s := &notificationServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, receivedRequest{path: r.URL.Path, header: r.Header, body: body})
+		s.mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	return s
+}
+
+// received returns the requests to the path
+func (s *notificationServer) received(path string) []receivedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []receivedRequest
+	for _, request := range s.requests {
+		if request.path == path {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// webhookEvents returns the events of the notifications POSTed to the webhook path
+func (s *notificationServer) webhookEvents(t *testing.T, path string) []string {
+	var events []string
+	for _, request := range s.received(path) {
+		var n notification
+		if err := json.Unmarshal(request.body, &n); err != nil {
+			t.Fatalf("webhook body %s: %v", request.body, err)
+		}
+		events = append(events, n.Event)
+	}
+	return events
+}
+
+func TestNotifierRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes map[string][]string
+		// want are the events received by each backend
+		want map[string][]string
+	}{
+		{
+			name: "without routes every event goes everywhere",
+			want: map[string][]string{
+				"telegram": {eventFill, eventDailySummary, eventRiskLimit},
+				"ops":      {eventFill, eventDailySummary, eventRiskLimit},
+				"trades":   {eventFill, eventDailySummary, eventRiskLimit},
+			},
+		},
+		{
+			name: "listed events and the default route",
+			routes: map[string][]string{
+				eventFill:      {"trades"},
+				eventRiskLimit: {"telegram", "ops", "unknown"},
+				"default":      {"ops"},
+			},
+			want: map[string][]string{
+				"telegram": {eventRiskLimit},
+				"ops":      {eventDailySummary, eventRiskLimit},
+				"trades":   {eventFill},
+			},
+		},
+		{
+			name:   "without a default route the events not listed go everywhere",
+			routes: map[string][]string{eventFill: {"trades"}},
+			want: map[string][]string{
+				"telegram": {eventDailySummary, eventRiskLimit},
+				"ops":      {eventDailySummary, eventRiskLimit},
+				"trades":   {eventFill, eventDailySummary, eventRiskLimit},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newNotificationServer()
+			defer server.Close()
+			notifier := newNotifier(NotifierConfig{
+				Telegram: TelegramConfig{BaseURL: server.URL, Token: "123:secret", ChatID: "42"},
+				Webhooks: map[string]WebhookConfig{"ops": {URL: server.URL + "/ops"}, "trades": {URL: server.URL + "/trades"}},
+				Routes:   test.routes,
+			}, zap.NewNop().Sugar())
+			notifier.notify(eventFill, "Bought %v lots", 10)
+			notifier.notify(eventDailySummary, "Day is over")
+			notifier.notify(eventRiskLimit, "Daily loss limit")
+			notifier.Close(5 * time.Second)
+
+			var telegram []string
+			for _, request := range server.received("/bot123:secret/sendMessage") {
+				var message map[string]string
+				if err := json.Unmarshal(request.body, &message); err != nil {
+					t.Fatalf("telegram body %s: %v", request.body, err)
+				}
+				event := strings.TrimPrefix(strings.SplitN(message["text"], "]", 2)[0], "[")
+				telegram = append(telegram, event)
+			}
+			got := map[string][]string{
+				"telegram": telegram,
+				"ops":      server.webhookEvents(t, "/ops"),
+				"trades":   server.webhookEvents(t, "/trades"),
+			}
+			for backend, events := range got {
+				if strings.Join(events, ",") != strings.Join(test.want[backend], ",") {
+					t.Errorf("%v received %v, want %v", backend, events, test.want[backend])
+				}
+			}
+		})
+	}
+}
+
+func TestNotifierPayloads(t *testing.T) {
+	server := newNotificationServer()
+	defer server.Close()
+	notifier := newNotifier(NotifierConfig{
+		Telegram: TelegramConfig{BaseURL: server.URL + "/", Token: "123:secret", ChatID: "42"},
+		Webhooks: map[string]WebhookConfig{"ops": {URL: server.URL + "/ops", Headers: map[string]string{"Authorization": "Bearer ops"}}},
+	}, zap.NewNop().Sugar())
+	notifier.notify(eventFill, "Bought %v lots of %v", 10, "TCSG")
+	notifier.Close(5 * time.Second)
+
+	telegram := server.received("/bot123:secret/sendMessage")
+	if len(telegram) != 1 {
+		t.Fatalf("telegram received %v requests, want 1", len(telegram))
+	}
+	var message map[string]string
+	if err := json.Unmarshal(telegram[0].body, &message); err != nil {
+		t.Fatalf("telegram body %s: %v", telegram[0].body, err)
+	}
+	if message["chat_id"] != "42" || message["text"] != "[fill] Bought 10 lots of TCSG" || len(message) != 2 {
+		t.Errorf("telegram message = %v", message)
+	}
+	if contentType := telegram[0].header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("telegram Content-Type = %v", contentType)
+	}
+
+	webhook := server.received("/ops")
+	if len(webhook) != 1 {
+		t.Fatalf("webhook received %v requests, want 1", len(webhook))
+	}
+	var n notification
+	if err := json.Unmarshal(webhook[0].body, &n); err != nil {
+		t.Fatalf("webhook body %s: %v", webhook[0].body, err)
+	}
+	if n.Event != eventFill || n.Text != "Bought 10 lots of TCSG" || n.Time.IsZero() {
+		t.Errorf("webhook notification = %+v", n)
+	}
+	if auth := webhook[0].header.Get("Authorization"); auth != "Bearer ops" {
+		t.Errorf("webhook Authorization = %v", auth)
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	server := newNotificationServer()
+	defer server.Close()
+	notifier := newNotifier(NotifierConfig{
+		Webhooks:     map[string]WebhookConfig{"ops": {URL: server.URL + "/ops"}},
+		MaxPerMinute: 2,
+	}, zap.NewNop().Sugar())
+	for i := 0; i < 5; i++ {
+		notifier.notify(eventFill, "Fill %v", i)
+	}
+	notifier.Close(5 * time.Second)
+
+	var texts []string
+	for _, request := range server.received("/ops") {
+		var n notification
+		if err := json.Unmarshal(request.body, &n); err != nil {
+			t.Fatalf("webhook body %s: %v", request.body, err)
+		}
+		texts = append(texts, n.Text)
+	}
+	if strings.Join(texts, "|") != "Fill 0|Fill 1" {
+		t.Errorf("webhook received %q, want the first 2 fills", texts)
+	}
+	if dropped := notifier.limiters["ops"].dropped; dropped != 3 {
+		t.Errorf("dropped = %v, want 3", dropped)
+	}
+}
+
+func TestNotifierReportsDropped(t *testing.T) {
+	server := newNotificationServer()
+	defer server.Close()
+	notifier := newNotifier(NotifierConfig{Webhooks: map[string]WebhookConfig{"ops": {URL: server.URL + "/ops"}}}, zap.NewNop().Sugar())
+	// the notifications dropped before are reported once with the next sent one
+	notifier.limiters["ops"].dropped = 3
+	notifier.notify(eventRiskLimit, "Daily loss limit")
+	notifier.notify(eventFill, "Sold")
+	notifier.Close(5 * time.Second)
+
+	var texts []string
+	for _, request := range server.received("/ops") {
+		var n notification
+		if err := json.Unmarshal(request.body, &n); err != nil {
+			t.Fatalf("webhook body %s: %v", request.body, err)
+		}
+		texts = append(texts, n.Text)
+	}
+	want := []string{"Daily loss limit\n(3 notifications were dropped by the rate limit)", "Sold"}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("webhook received %q, want %q", texts, want)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		after   time.Duration
+		allowed bool
+	}{
+		{name: "first", allowed: true},
+		{name: "second", allowed: true},
+		{name: "third in the same minute", allowed: false},
+		{name: "half a token later", after: 15 * time.Second, allowed: false},
+		{name: "a token later", after: 30 * time.Second, allowed: true},
+		{name: "a long pause refills no more than the limit", after: time.Hour, allowed: true},
+		{name: "second after the pause", after: time.Hour, allowed: true},
+		{name: "third after the pause", after: time.Hour, allowed: false},
+	}
+	limiter := &rateLimiter{perMinute: 2}
+	for _, test := range tests {
+		if got := limiter.allow(start.Add(test.after)); got != test.allowed {
+			t.Errorf("%v: allow = %v, want %v", test.name, got, test.allowed)
+		}
+	}
+	if limiter.dropped != 3 {
+		t.Errorf("dropped = %v, want 3", limiter.dropped)
+	}
+}
+
+func TestRedactBotToken(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/bot123:secret/sendMessage", want: "/bot<redacted>/sendMessage"},
+		{path: "/bot123:secret", want: "/bot<redacted>"},
+		{path: "/ops", want: "/ops"},
+		{path: "/", want: "/"},
+	}
+	for _, test := range tests {
+		if got := redactBotToken(test.path); got != test.want {
+			t.Errorf("redactBotToken(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"math"
+	"time"
+)
+
+// orderExecutor sends BUY/SELL orders for the strategy according to the execution settings of the instrument
+type orderExecutor struct {
+	ordersService      *investgo.OrdersServiceClient
+	marketDataService  *investgo.MarketDataServiceClient
+	instrumentsService *investgo.InstrumentsServiceClient
+	accountId          string
+	execution          ExecutionConfig
+	// minimum price step of the instrument, limit prices must be multiples of it
+	priceSteps map[string]*pb.Quotation
+	fees       FeeConfig
+	journal    *Journal
+	logger     investgo.Logger
+}
+
+func newOrderExecutor(client *investgo.Client, accountId string, execution ExecutionConfig, fees FeeConfig, journal *Journal, logger investgo.Logger) *orderExecutor {
+	return &orderExecutor{
+		ordersService:      client.NewOrdersServiceClient(),
+		marketDataService:  client.NewMarketDataServiceClient(),
+		instrumentsService: client.NewInstrumentsServiceClient(),
+		accountId:          accountId,
+		execution:          execution,
+		priceSteps:         make(map[string]*pb.Quotation),
+		fees:               fees,
+		journal:            journal,
+		logger:             logger,
+	}
+}
+
+// buy returns what was executed by all orders that were needed to buy quantity lots
+func (e *orderExecutor) buy(instrumentId string, quantity int64) (OrderResult, error) {
+	if e.execution.Mode != executionModeLimit {
+		return e.market(instrumentId, pb.OrderDirection_ORDER_DIRECTION_BUY, quantity)
+	}
+	return e.chaseLimitOrder(instrumentId, pb.OrderDirection_ORDER_DIRECTION_BUY, quantity)
+}
+
+func (e *orderExecutor) sell(instrumentId string, quantity int64) (OrderResult, error) {
+	if e.execution.Mode != executionModeLimit {
+		return e.market(instrumentId, pb.OrderDirection_ORDER_DIRECTION_SELL, quantity)
+	}
+	return e.chaseLimitOrder(instrumentId, pb.OrderDirection_ORDER_DIRECTION_SELL, quantity)
+}
+
+// chaseLimitOrder places a limit order at the best bid (BUY) or best ask (SELL) and moves it after the book every
+// RepriceIntervalSeconds. When TimeoutSeconds expire the order is cancelled and the rest is bought/sold by market.
+func (e *orderExecutor) chaseLimitOrder(instrumentId string, direction pb.OrderDirection, quantity int64) (OrderResult, error) {
+	result := OrderResult{LotsRequested: quantity}
+	price, err := e.getLimitPrice(instrumentId, direction)
+	if err != nil {
+		return e.marketOrder(instrumentId, direction, result)
+	}
+	orderId := investgo.CreateUid()
+	e.recordRequest(instrumentId, orderId, direction, quantity, price)
+	e.logger.Infof("Sent limit %v request: quantity = %v, price = %v", direction.String(), quantity, price.ToFloat())
+	orderResp, err := e.ordersService.PostOrder(&investgo.PostOrderRequest{
+		InstrumentId: instrumentId,
+		Quantity:     quantity,
+		Price:        price,
+		Direction:    direction,
+		AccountId:    e.accountId,
+		OrderType:    pb.OrderType_ORDER_TYPE_LIMIT,
+		OrderId:      orderId,
+	})
+	if err != nil {
+		e.logger.Errorf("Failed to place limit order: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(orderResp.GetHeader()))
+		return e.marketOrder(instrumentId, direction, result)
+	}
+	orderId = orderResp.GetOrderId()
+
+	deadline := time.Now().Add(time.Duration(e.execution.TimeoutSeconds) * time.Second)
+	repriceInterval := time.Duration(e.execution.RepriceIntervalSeconds) * time.Second
+	for {
+		wait := repriceInterval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		time.Sleep(wait)
+
+		state, err := e.ordersService.GetOrderState(e.accountId, orderId)
+		if err != nil {
+			e.logger.Errorf("Can't get limit order state: %v", err.Error())
+			if time.Now().Before(deadline) {
+				continue
+			}
+			// we don't know how much was executed, so we don't risk sending the market order for the whole rest
+			e.cancelOrder(orderId)
+			// the cancelled order could be partially filled, the state may be readable again
+			if finalState, stateErr := e.ordersService.GetOrderState(e.accountId, orderId); stateErr == nil {
+				e.recordState(instrumentId, direction, finalState)
+				result.add(e.recordFill(instrumentId, direction, orderStateResult(finalState)))
+			}
+			// the lots of the replaced orders and of the cancelled one are on the account, the strategy has to account for them
+			if result.LotsExecuted > 0 {
+				return result, nil
+			}
+			return result, err
+		}
+		e.recordState(instrumentId, direction, state)
+		status := state.GetExecutionReportStatus()
+		if status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL {
+			result.add(e.recordFill(instrumentId, direction, orderStateResult(state)))
+			e.logger.Infof("Limit order is filled: lotsExecuted = %v, priceOrderExecuted = %v", result.LotsExecuted, result.PriceOrderExecuted)
+			return result, nil
+		}
+		if status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED || status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED {
+			result.add(e.recordFill(instrumentId, direction, orderStateResult(state)))
+			e.logger.Infof("Limit order was %v, sending the rest by market", status.String())
+			return e.marketOrder(instrumentId, direction, result)
+		}
+		if !time.Now().Before(deadline) {
+			e.logger.Infof("Limit order timeout expired, sending the rest by market")
+			e.cancelOrder(orderId)
+			// the order could be partially filled before cancellation
+			if finalState, err := e.ordersService.GetOrderState(e.accountId, orderId); err == nil {
+				e.recordState(instrumentId, direction, finalState)
+				state = finalState
+			}
+			result.add(e.recordFill(instrumentId, direction, orderStateResult(state)))
+			return e.marketOrder(instrumentId, direction, result)
+		}
+
+		newPrice, err := e.getLimitPrice(instrumentId, direction)
+		if err != nil || (newPrice.GetUnits() == price.GetUnits() && newPrice.GetNano() == price.GetNano()) {
+			continue
+		}
+		rest := quantity - result.LotsExecuted - state.GetLotsExecuted()
+		newOrderId := investgo.CreateUid()
+		e.recordRequest(instrumentId, newOrderId, direction, rest, newPrice)
+		e.logger.Infof("Sent replace order request: price %v -> %v, quantity = %v", price.ToFloat(), newPrice.ToFloat(), rest)
+		replaceResp, err := e.ordersService.ReplaceOrder(&investgo.ReplaceOrderRequest{
+			AccountId:  e.accountId,
+			OrderId:    orderId,
+			NewOrderId: newOrderId,
+			Quantity:   rest,
+			Price:      newPrice,
+			PriceType:  pb.PriceType_PRICE_TYPE_CURRENCY,
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to replace limit order: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(replaceResp.GetHeader()))
+			continue
+		}
+		// lots executed by the replaced order are not visible in the new one
+		if oldState, err := e.ordersService.GetOrderState(e.accountId, orderId); err == nil {
+			e.recordState(instrumentId, direction, oldState)
+			state = oldState
+		}
+		result.add(e.recordFill(instrumentId, direction, orderStateResult(state)))
+		orderId = replaceResp.GetOrderId()
+		price = newPrice
+	}
+}
+
+// marketOrder buys/sells what is left after the limit order and adds it to the already executed result
+func (e *orderExecutor) marketOrder(instrumentId string, direction pb.OrderDirection, result OrderResult) (OrderResult, error) {
+	rest := result.LotsRequested - result.LotsExecuted
+	if rest <= 0 {
+		return result, nil
+	}
+	restResult, err := e.market(instrumentId, direction, rest)
+	if err != nil {
+		if result.LotsExecuted > 0 {
+			// part of the order is already executed, the strategy has to account for it
+			return result, nil
+		}
+		return OrderResult{}, err
+	}
+	result.add(restResult)
+	return result, nil
+}
+
+// market sends the market order and records its fill
+func (e *orderExecutor) market(instrumentId string, direction pb.OrderDirection, quantity int64) (OrderResult, error) {
+	var (
+		result OrderResult
+		err    error
+	)
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		result, err = buy(e.ordersService, instrumentId, e.accountId, quantity, e.journal, e.logger)
+	} else {
+		result, err = sell(e.ordersService, instrumentId, e.accountId, quantity, e.journal, e.logger)
+	}
+	if err != nil {
+		return result, err
+	}
+	return e.recordFill(instrumentId, direction, result), nil
+}
+
+func (e *orderExecutor) cancelOrder(orderId string) {
+	e.logger.Infof("Sent cancel order request")
+	cancelResp, err := e.ordersService.CancelOrder(e.accountId, orderId)
+	if err != nil {
+		e.logger.Errorf("Failed to cancel order: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(cancelResp.GetHeader()))
+	}
+}
+
+// getLimitPrice takes the best bid for BUY and the best ask for SELL and moves it LimitOffsetTicks towards the other side of the book.
+// The price stays passive: it never reaches the other side, crossing the spread is the job of the market fallback.
+func (e *orderExecutor) getLimitPrice(instrumentId string, direction pb.OrderDirection) (*pb.Quotation, error) {
+	step, err := e.getPriceStep(instrumentId)
+	if err != nil {
+		return nil, err
+	}
+	orderBookResp, err := e.marketDataService.GetOrderBook(instrumentId, 1)
+	if err != nil {
+		e.logger.Errorf("Can't get order book: %v", err.Error())
+		return nil, err
+	}
+	bids, asks := orderBookResp.GetBids(), orderBookResp.GetAsks()
+	if len(bids) == 0 || len(asks) == 0 {
+		e.logger.Infof("Order book is empty: %v bids, %v asks", len(bids), len(asks))
+		return nil, errors.New("order book is empty")
+	}
+	bestBid, bestAsk := bids[0].GetPrice().ToFloat(), asks[0].GetPrice().ToFloat()
+	offset := float64(e.execution.LimitOffsetTicks) * step.ToFloat()
+	price := bestBid + offset
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		// at most one step below the best ask, the best bid if the spread is one step
+		if price >= bestAsk {
+			price = math.Max(bestAsk-step.ToFloat(), bestBid)
+		}
+	} else {
+		price = bestAsk - offset
+		if price <= bestBid {
+			price = math.Min(bestBid+step.ToFloat(), bestAsk)
+		}
+	}
+	return investgo.FloatToQuotation(price, step), nil
+}
+
+func (e *orderExecutor) getPriceStep(instrumentId string) (*pb.Quotation, error) {
+	if step, ok := e.priceSteps[instrumentId]; ok {
+		return step, nil
+	}
+	instrumentResp, err := e.instrumentsService.InstrumentByUid(instrumentId)
+	if err != nil {
+		e.logger.Errorf("Can't get instrument info: %v", err.Error())
+		return nil, err
+	}
+	step := instrumentResp.GetInstrument().GetMinPriceIncrement()
+	e.priceSteps[instrumentId] = step
+	return step, nil
+}
+
+func journalDirection(direction pb.OrderDirection) string {
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		return directionBuy
+	}
+	return directionSell
+}
+
+func (e *orderExecutor) recordRequest(instrumentId string, orderId string, direction pb.OrderDirection, quantity int64, price *pb.Quotation) {
+	e.journal.record(JournalRecord{
+		Kind:          journalOrderRequest,
+		InstrumentID:  instrumentId,
+		OrderID:       orderId,
+		Direction:     journalDirection(direction),
+		OrderType:     pb.OrderType_ORDER_TYPE_LIMIT.String(),
+		LotsRequested: quantity,
+		Price:         price.ToFloat(),
+	})
+}
+
+func (e *orderExecutor) recordState(instrumentId string, direction pb.OrderDirection, state *investgo.GetOrderStateResponse) {
+	botMetrics.inc(metricOrders, "direction", journalDirection(direction), "status", state.GetExecutionReportStatus().String())
+	e.journal.record(JournalRecord{
+		Kind:          journalOrderState,
+		InstrumentID:  instrumentId,
+		OrderID:       state.GetOrderId(),
+		Direction:     journalDirection(direction),
+		Status:        state.GetExecutionReportStatus().String(),
+		LotsRequested: state.GetLotsRequested(),
+		LotsExecuted:  state.GetLotsExecuted(),
+		Amount:        state.GetExecutedOrderPrice().ToFloat(),
+	})
+}
+
+func orderStateResult(state *investgo.GetOrderStateResponse) OrderResult {
+	return OrderResult{
+		OrderID:            state.GetOrderId(),
+		LotsExecuted:       state.GetLotsExecuted(),
+		LotsRequested:      state.GetLotsRequested(),
+		PriceOrderExecuted: state.GetExecutedOrderPrice().ToFloat(),
+		Commission:         orderCommission(state.GetExecutedCommission(), state.GetInitialCommission()),
+	}
+}
+
+// recordFill writes what the finished order executed to the journal and returns it.
+// If the exchange didn't report the commission, it is estimated by the fee model.
+func (e *orderExecutor) recordFill(instrumentId string, direction pb.OrderDirection, executed OrderResult) OrderResult {
+	if executed.LotsExecuted == 0 {
+		return executed
+	}
+	estimated := false
+	if executed.Commission == 0 {
+		executed.Commission = e.fees.commission(executed.PriceOrderExecuted)
+		estimated = executed.Commission != 0
+	}
+	e.journal.record(JournalRecord{
+		Kind:                journalFill,
+		InstrumentID:        instrumentId,
+		OrderID:             executed.OrderID,
+		Direction:           journalDirection(direction),
+		LotsExecuted:        executed.LotsExecuted,
+		Amount:              executed.PriceOrderExecuted,
+		Commission:          executed.Commission,
+		CommissionEstimated: estimated,
+	})
+	botMetrics.observeFill(instrumentId, journalDirection(direction), executed.LotsExecuted, executed.PriceOrderExecuted)
+	return executed
+}
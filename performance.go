@@ -0,0 +1,185 @@
+package main
+
+import (
+	"TradingBot/analytics"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"math"
+	"sort"
+	"time"
+)
+
+// minutesPerTradingYear annualizes Sharpe and Sortino of the minute equity curve
+var minutesPerTradingYear = float64(252 * (closeMoscowHour*60 + closeMoscowMinute - openMoscowHour*60 - openMoscowMinute))
+
+// periodsPerTradingYear annualizes the metrics of an equity curve with a point every interval: the candles of a trading
+// day times 252, a daily candle is one period a day
+func periodsPerTradingYear(interval time.Duration) float64 {
+	if interval <= time.Minute {
+		return minutesPerTradingYear
+	}
+	return 252 * math.Ceil(minutesPerTradingYear/252/interval.Minutes())
+}
+
+func sortedByTime(records []JournalRecord) []JournalRecord {
+	sorted := append([]JournalRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	return sorted
+}
+
+// commissionScale returns how the commissions of the fills have to be scaled so that their sum equals the actual commission
+// charged by the broker (journal "commission" record), 1 if the actual commission is unknown
+func commissionScale(records []JournalRecord, instrumentId string) float64 {
+	used, actual := 0.0, 0.0
+	for _, rec := range records {
+		if rec.InstrumentID != instrumentId {
+			continue
+		}
+		if rec.Kind == journalFill {
+			used += rec.Commission
+		} else if rec.Kind == journalCommission {
+			actual = rec.Commission
+		}
+	}
+	if used == 0 || actual == 0 {
+		return 1
+	}
+	return actual / used
+}
+
+// fillsFromJournal converts the fills of the instrument to analytics fills
+func fillsFromJournal(records []JournalRecord, instrumentId string) []analytics.Fill {
+	var fills []analytics.Fill
+	scale := commissionScale(records, instrumentId)
+	for _, rec := range records {
+		if rec.Kind != journalFill || rec.InstrumentID != instrumentId {
+			continue
+		}
+		side := analytics.Buy
+		if rec.Direction == directionSell {
+			side = analytics.Sell
+		}
+		fills = append(fills, analytics.Fill{Time: rec.Time, Side: side, Lots: rec.LotsExecuted, Amount: rec.Amount, Commission: rec.Commission * scale})
+	}
+	return fills
+}
+
+// equityFromJournal builds the minute equity curve: money plus the position valued at the candle close of every signal.
+// Position snapshots set money and position, fills change them until the next snapshot. The signals before the first
+// money snapshot have no equity.
+func equityFromJournal(records []JournalRecord, instrumentId string) []analytics.EquityPoint {
+	var (
+		equity     []analytics.EquityPoint
+		money      float64
+		lots       int64
+		moneyKnown bool
+	)
+	scale := commissionScale(records, instrumentId)
+	for _, rec := range sortedByTime(records) {
+		switch rec.Kind {
+		case journalPosition:
+			if rec.InstrumentID == "" {
+				money = rec.Money
+				moneyKnown = true
+			} else if rec.InstrumentID == instrumentId {
+				lots = rec.Balance
+			}
+		case journalFill:
+			if rec.InstrumentID != instrumentId {
+				continue
+			}
+			if rec.Direction == directionBuy {
+				money -= rec.Amount + rec.Commission*scale
+				lots += rec.LotsExecuted
+			} else {
+				money += rec.Amount - rec.Commission*scale
+				lots -= rec.LotsExecuted
+			}
+		case journalSignal:
+			if rec.InstrumentID == instrumentId && rec.Price > 0 && moneyKnown {
+				equity = append(equity, analytics.EquityPoint{Time: rec.Time, Equity: money + float64(lots)*rec.Price})
+			}
+		}
+	}
+	return equity
+}
+
+// performanceFromJournal calculates trades, the equity curve and all performance metrics of the instrument from the journal records,
+// periodsPerYear annualizes the ratios of the equity curve
+func performanceFromJournal(records []JournalRecord, instrumentId string, periodsPerYear float64) (analytics.Summary, []analytics.Trade, []analytics.EquityPoint) {
+	trades := analytics.Trades(fillsFromJournal(records, instrumentId))
+	equity := equityFromJournal(records, instrumentId)
+	return analytics.Summarize(trades, equity, periodsPerYear), trades, equity
+}
+
+// benchmarkCurve is a named curve the equity of the strategy is compared with
+type benchmarkCurve struct {
+	Name   string
+	Points []analytics.EquityPoint
+}
+
+// benchmarkResult is the comparison with one benchmark, Curve is the benchmark at the equity points starting with the equity
+type benchmarkResult struct {
+	Name  string
+	Curve []analytics.EquityPoint
+	Stats analytics.BenchmarkStats
+}
+
+// buyAndHoldFromJournal is the price of the instrument at the signals of the journal: the value of buying it at the first signal
+func buyAndHoldFromJournal(records []JournalRecord, instrumentId string) benchmarkCurve {
+	curve := benchmarkCurve{Name: "buy_and_hold"}
+	for _, rec := range sortedByTime(records) {
+		if rec.Kind == journalSignal && rec.InstrumentID == instrumentId && rec.Price > 0 {
+			curve.Points = append(curve.Points, analytics.EquityPoint{Time: rec.Time, Equity: rec.Price})
+		}
+	}
+	return curve
+}
+
+// loadIndexBenchmark reads the closes of the index of the config in [from, to) from the candle store, nothing if no index is set
+func loadIndexBenchmark(historyDir string, benchmark BenchmarkConfig, from time.Time, to time.Time) (benchmarkCurve, error) {
+	curve := benchmarkCurve{Name: benchmark.Index}
+	if benchmark.Index == "" {
+		return curve, nil
+	}
+	store, err := openCandleStore(historyDir)
+	if err != nil {
+		return curve, err
+	}
+	candles, err := store.read(benchmark.Index, benchmark.Interval, from, to)
+	if err != nil {
+		return curve, err
+	}
+	for _, candle := range candles {
+		curve.Points = append(curve.Points, analytics.EquityPoint{Time: candle.Datetime, Equity: candle.Close})
+	}
+	return curve, nil
+}
+
+// compareBenchmarks compares the equity with buy-and-hold of the instrument and with the curves given, the empty ones are skipped.
+// periodsPerYear annualizes the alpha
+func compareBenchmarks(records []JournalRecord, instrumentId string, equity []analytics.EquityPoint, periodsPerYear float64, curves ...benchmarkCurve) []benchmarkResult {
+	var results []benchmarkResult
+	for _, curve := range append([]benchmarkCurve{buyAndHoldFromJournal(records, instrumentId)}, curves...) {
+		aligned := analytics.AlignBenchmark(equity, curve.Points)
+		if len(aligned) < 2 {
+			continue
+		}
+		results = append(results, benchmarkResult{
+			Name:  curve.Name,
+			Curve: analytics.ScaleCurve(aligned, equity[0].Equity),
+			Stats: analytics.CompareBenchmark(equity, aligned, periodsPerYear),
+		})
+	}
+	return results
+}
+
+func logPerformance(summary analytics.Summary, logger investgo.Logger) {
+	logger.Infof("Net profit after fees => %.2f RUB (fees %.2f RUB)", summary.NetPnL, summary.Fees)
+	logger.Infof("Closed trades => %v, win rate => %.2f %%", summary.Trades, summary.WinRate*100)
+	logger.Infof("Profit factor => %.2f, expectancy => %.2f RUB per trade", summary.ProfitFactor, summary.Expectancy)
+	logger.Infof("Maximum drawdown => %.2f RUB (%.2f %%)", summary.MaxDrawdown, summary.MaxDrawdownPercent*100)
+	logger.Infof("Sharpe => %.2f, Sortino => %.2f", summary.Sharpe, summary.Sortino)
+	logger.Infof("Exposure => %.2f %%, average holding time => %v", summary.Exposure*100, summary.AverageHolding)
+}
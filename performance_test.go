@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodsPerTradingYear(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     float64
+	}{
+		{interval: 0, want: 252 * 720},
+		{interval: time.Minute, want: 252 * 720},
+		{interval: 5 * time.Minute, want: 252 * 144},
+		{interval: 15 * time.Minute, want: 252 * 48},
+		{interval: time.Hour, want: 252 * 12},
+		{interval: 24 * time.Hour, want: 252},
+	}
+	for _, test := range tests {
+		if got := periodsPerTradingYear(test.interval); got != test.want {
+			t.Errorf("periodsPerTradingYear(%v) = %v, want %v", test.interval, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Predictor gives the action for the candle. httpPredictor asks a Python server of predictors, rsiPredictor is the RSI
+// rule written in Go and ensemblePredictor combines other predictors, the main loop doesn't see the difference.
+type Predictor interface {
+	predict(request RequestToPredict) (ResponseAction, error)
+}
+
+// rsiPredictorName is the name of the Go RSI rule among the predictors
+const rsiPredictorName = "go_rsi"
+
+// httpPredictor remembers the last answer: the ensembles and the shadow predictors that share it ask it about the same
+// candle, the server gets one request per candle. The lock is held during the request, so a concurrent call waits for
+// the answer instead of sending its own. Errors are not remembered, the next call asks again.
+type httpPredictor struct {
+	mu   sync.Mutex
+	name string
+	url  string
+	// metrics count the failed requests by the name, nil in backtests
+	metrics  *Metrics
+	logger   investgo.Logger
+	asked    bool
+	lastId   uint64
+	lastTime time.Time
+	last     ResponseAction
+}
+
+func (p *httpPredictor) predict(request RequestToPredict) (ResponseAction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.asked && request.ReqId == p.lastId && request.Datetime.Equal(p.lastTime) {
+		return p.last, nil
+	}
+	id := request.ReqId
+	response, err := send_request(request, p.url, &id, p.name, p.metrics, p.logger)
+	if err != nil {
+		return response, err
+	}
+	p.asked, p.lastId, p.lastTime, p.last = true, request.ReqId, request.Datetime, response
+	return response, nil
+}
+
+// RSIConfig sets the Go predictor "go_rsi": BUY when the RSI of the last Period closes falls below Oversold,
+// SELL when it rises above Overbought, HOLD otherwise
+type RSIConfig struct {
+	Period     int     `yaml:"period"`
+	Oversold   float64 `yaml:"oversold"`
+	Overbought float64 `yaml:"overbought"`
+}
+
+// rsiConfig returns the RSI rule, 14 candles, 30 and 70 by default
+func (c Config) rsiConfig() RSIConfig {
+	rsi := c.RSI
+	if rsi.Period <= 0 {
+		rsi.Period = 14
+	}
+	if rsi.Oversold == 0 {
+		rsi.Oversold = 30
+	}
+	if rsi.Overbought == 0 {
+		rsi.Overbought = 70
+	}
+	return rsi
+}
+
+// rsiPredictor keeps the last closes. It may be called by several ensembles and the shadow predictors with the same
+// candle, a candle of the same time replaces the last close instead of adding one.
+type rsiPredictor struct {
+	mu     sync.Mutex
+	config RSIConfig
+	closes []float64
+	last   time.Time
+}
+
+func newRSIPredictor(config RSIConfig) *rsiPredictor {
+	return &rsiPredictor{config: config}
+}
+
+func (p *rsiPredictor) predict(request RequestToPredict) (ResponseAction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.closes) > 0 && request.Datetime.Equal(p.last) {
+		p.closes[len(p.closes)-1] = request.Close
+	} else {
+		p.closes = append(p.closes, request.Close)
+		if len(p.closes) > p.config.Period+1 {
+			p.closes = p.closes[1:]
+		}
+		p.last = request.Datetime
+	}
+	response := ResponseAction{RespId: int(request.ReqId)}
+	if len(p.closes) <= p.config.Period {
+		return response, nil
+	}
+	gain, loss := 0.0, 0.0
+	for i := 1; i < len(p.closes); i++ {
+		if change := p.closes[i] - p.closes[i-1]; change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	rsi := 100.0
+	if loss > 0 {
+		rsi = 100 - 100/(1+gain/loss)
+	}
+	switch {
+	case rsi < p.config.Oversold:
+		response.Action = 1
+	case rsi > p.config.Overbought:
+		response.Action = 2
+	}
+	return response, nil
+}
+
+// hasPredictor says the name is a predictor of the config: a Python server, the Go RSI rule or an ensemble
+func (c Config) hasPredictor(name string) bool {
+	if _, ok := c.predictors()[name]; ok || name == rsiPredictorName {
+		return true
+	}
+	_, ok := c.Ensembles[name]
+	return ok
+}
+
+// newPredictors returns every predictor of the config by name. The ensembles share the predictors they combine:
+// the RSI rule counts a candle once and a Python server gets one request per candle whichever ensemble or shadow asks.
+// The failed requests of the Python servers are counted in metrics.
+func newPredictors(c Config, metrics *Metrics, logger investgo.Logger) map[string]Predictor {
+	predictors := map[string]Predictor{rsiPredictorName: newRSIPredictor(c.rsiConfig())}
+	for name, url := range c.predictors() {
+		predictors[name] = &httpPredictor{name: name, url: url, metrics: metrics, logger: logger}
+	}
+	names := make([]string, 0, len(c.Ensembles))
+	for name := range c.Ensembles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config := c.Ensembles[name]
+		members := make([]ensembleMember, 0, len(config.Members))
+		for _, member := range config.Members {
+			members = append(members, ensembleMember{EnsembleMemberConfig: member, predictor: predictors[member.Predictor]})
+		}
+		predictors[name] = newEnsemblePredictor(name, config, members, logger)
+	}
+	return predictors
+}
+
+// validateEnsembles returns the problems of the ensembles of the config
+func validateEnsembles(c Config) []string {
+	var problems []string
+	for name, ensemble := range c.Ensembles {
+		if _, ok := c.predictors()[name]; ok || name == rsiPredictorName {
+			problems = append(problems, fmt.Sprintf("Ensemble %q has the name of a predictor", name))
+		}
+		switch ensemble.Rule {
+		case "", ensembleMajority, ensembleWeighted, ensembleUnanimousBuy:
+		default:
+			problems = append(problems, fmt.Sprintf("Unknown rule %q of ensemble %q, use majority, weighted or unanimous_buy", ensemble.Rule, name))
+		}
+		if ensemble.MinConfidence < 0 || ensemble.MinConfidence > 1 {
+			problems = append(problems, fmt.Sprintf("min_confidence of ensemble %q must be in [0, 1]", name))
+		}
+		if len(ensemble.Members) == 0 {
+			problems = append(problems, fmt.Sprintf("Ensemble %q has no members", name))
+		}
+		for _, member := range ensemble.Members {
+			if _, ok := c.predictors()[member.Predictor]; !ok && member.Predictor != rsiPredictorName {
+				problems = append(problems, fmt.Sprintf("Member %q of ensemble %q is not a predictor, ensembles can't be nested", member.Predictor, name))
+			}
+			if member.Weight < 0 {
+				problems = append(problems, fmt.Sprintf("Weight of %q in ensemble %q can't be negative", member.Predictor, name))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPPredictorAsksOncePerCandle(t *testing.T) {
+	var requests int64
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if fail {
+			_, _ = w.Write([]byte(`{"Error":"model is not loaded"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"RespId":1,"Action":1,"Probabilities":[0.2,0.7,0.1]}`))
+	}))
+	defer server.Close()
+	predictor := &httpPredictor{name: "candidate", url: server.URL, metrics: newBotMetrics(), logger: zap.NewNop().Sugar()}
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, moscowLocation())
+
+	// the main loop, the ensembles and the shadows ask about the same candle at once
+	candle := RequestToPredict{ReqId: 1, Datetime: start, Close: 100}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if response, err := predictor.predict(candle); err != nil || response.Action != 1 {
+				t.Errorf("predict = %+v, %v", response, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Errorf("%v requests about one candle, want 1", requests)
+	}
+
+	if _, err := predictor.predict(RequestToPredict{ReqId: 2, Datetime: start.Add(time.Minute), Close: 101}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("%v requests about two candles, want 2", requests)
+	}
+
+	// an error is not remembered
+	fail = true
+	next := RequestToPredict{ReqId: 3, Datetime: start.Add(2 * time.Minute), Close: 102}
+	for i := 0; i < 2; i++ {
+		if _, err := predictor.predict(next); err == nil {
+			t.Errorf("predict of a failing server returned no error")
+		}
+	}
+	if requests != 4 {
+		t.Errorf("%v requests after two errors, want 4", requests)
+	}
+	// the errors are counted for the predictor that failed
+	var b strings.Builder
+	if err := predictor.metrics.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := metricPredictorErrors + `{predictor="candidate",type="server"} 2`; !strings.Contains(b.String(), want) {
+		t.Errorf("metrics have no %v:\n%v", want, b.String())
+	}
+}
@@ -1,45 +1,381 @@
 package main
 
 import (
-	"flag"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v3"
 	"log"
 	"os"
+	"strings"
 )
 
 type Config struct {
-	Token     string `yaml:"token"`
-	Port      int    `yaml:"server_port"`
-	TargetAPI string `yaml:"target_api"`
-	AccountID string `yaml:"account_id"`
+	Mode           string                     `yaml:"mode"`
+	Token          string                     `yaml:"token"`
+	Port           int                        `yaml:"server_port"`
+	TargetAPI      string                     `yaml:"target_api"`
+	AccountID      string                     `yaml:"account_id"`
+	Execution      map[string]ExecutionConfig `yaml:"execution"`
+	JournalDir     string                     `yaml:"journal_dir"`
+	CheckpointDir  string                     `yaml:"checkpoint_dir"`
+	Reconciliation ReconciliationConfig       `yaml:"reconciliation"`
+	Fees           FeeConfig                  `yaml:"fees"`
+	ReportsDir     string                     `yaml:"reports_dir"`
+	BrokerReport   BrokerReportConfig         `yaml:"broker_report"`
+	HTTPAddr       string                     `yaml:"http_addr"`
+	Health         HealthConfig               `yaml:"health"`
+	Admin          AdminConfig                `yaml:"admin"`
+	Predictors     map[string]string          `yaml:"predictors"`
+	Shadows        []string                   `yaml:"shadow_predictors"`
+	Ensembles      map[string]EnsembleConfig  `yaml:"ensembles"`
+	RSI            RSIConfig                  `yaml:"rsi_model"`
+	Notifications  NotifierConfig             `yaml:"notifications"`
+	Logging        LoggingConfig              `yaml:"logging"`
+	Live           LiveConfig                 `yaml:"live"`
+	Risk           RiskConfig                 `yaml:"risk"`
+	PaperMoney     float64                    `yaml:"paper_money"`
+	HistoryDir     string                     `yaml:"history_dir"`
+	DataQuality    DataQualityConfig          `yaml:"data_quality"`
+	FillModel      FillModelConfig            `yaml:"fill_model"`
+	Benchmark      BenchmarkConfig            `yaml:"benchmark"`
+	Recording      RecordingConfig            `yaml:"recording"`
 }
 
-func getConfigFilePath() string {
-	configFilePath := flag.String("config", "", "a filepath to the config file")
-	flag.Parse()
-	return *configFilePath
+// ExecutionConfig describes how orders for one instrument are sent to the exchange.
+// Mode "market" (default) sends market orders, mode "limit" places a limit order at the best bid/ask
+// and chases the price until the order is filled or the timeout expires, then falls back to market.
+type ExecutionConfig struct {
+	Mode                   string `yaml:"mode"`
+	LimitOffsetTicks       int64  `yaml:"limit_offset_ticks"`
+	RepriceIntervalSeconds int    `yaml:"reprice_interval_seconds"`
+	TimeoutSeconds         int    `yaml:"timeout_seconds"`
 }
 
-func readConfig(path string) Config {
+const (
+	executionModeMarket = "market"
+	executionModeLimit  = "limit"
+)
+
+// executionFor returns execution settings for the ticker, "default" entry is used for the tickers not listed in config
+func (c Config) executionFor(ticker string) ExecutionConfig {
+	execution, ok := c.Execution[ticker]
+	if !ok {
+		execution = c.Execution["default"]
+	}
+	if execution.Mode == "" {
+		execution.Mode = executionModeMarket
+	}
+	if execution.RepriceIntervalSeconds <= 0 {
+		execution.RepriceIntervalSeconds = 5
+	}
+	if execution.TimeoutSeconds <= 0 {
+		execution.TimeoutSeconds = 30
+	}
+	return execution
+}
+
+// journalDir returns the directory of the trade journal, "./journal" by default
+func (c Config) journalDir() string {
+	if c.JournalDir == "" {
+		return "./journal"
+	}
+	return c.JournalDir
+}
+
+// reportsDir returns the directory of the end-of-day reports, "./reports" by default
+func (c Config) reportsDir() string {
+	if c.ReportsDir == "" {
+		return "./reports"
+	}
+	return c.ReportsDir
+}
+
+// httpAddr returns the address of the monitoring server, "localhost:9090" by default
+func (c Config) httpAddr() string {
+	if c.HTTPAddr == "" {
+		return "localhost:9090"
+	}
+	return c.HTTPAddr
+}
+
+// predictors returns the URLs of the predictors the admin API can switch between,
+// "default" is the Python server on server_port unless it is set explicitly
+func (c Config) predictors() map[string]string {
+	predictors := map[string]string{"default": fmt.Sprintf("http://localhost:%v/data", c.Port)}
+	for name, url := range c.Predictors {
+		predictors[name] = url
+	}
+	return predictors
+}
+
+// adminConfig returns the admin API settings, it listens on "localhost:9091" by default
+func (c Config) adminConfig() AdminConfig {
+	admin := c.Admin
+	if admin.Addr == "" {
+		admin.Addr = "localhost:9091"
+	}
+	return admin
+}
+
+// checkpointDir returns the directory of the strategy state checkpoints, "./state" by default
+func (c Config) checkpointDir() string {
+	if c.CheckpointDir == "" {
+		return "./state"
+	}
+	return c.CheckpointDir
+}
+
+// reconciliationConfig returns reconciliation settings: by default the drift is checked every 5 minutes and only reported,
+// trading is halted on any position drift or on the cash drift bigger than 1000 RUB
+func (c Config) reconciliationConfig() ReconciliationConfig {
+	reconciliation := c.Reconciliation
+	if reconciliation.IntervalSeconds <= 0 {
+		reconciliation.IntervalSeconds = 300
+	}
+	if reconciliation.Policy == "" {
+		reconciliation.Policy = reconciliationPolicyReport
+	}
+	if reconciliation.MaxCashDrift <= 0 {
+		reconciliation.MaxCashDrift = 1000
+	}
+	return reconciliation
+}
+
+// feeConfig returns the fee model, the "investor" tariff is used if neither tariff nor percent is set
+func (c Config) feeConfig() FeeConfig {
+	fees := c.Fees
+	if fees.Tariff == "" && fees.Percent == 0 {
+		fees.Tariff = "investor"
+	}
+	return fees
+}
+
+// brokerFeeConfig returns the fee model of the orders sent to the exchange. The sandbox charges no commission and
+// reports none, an estimate would make the money of the bot drift from the account until reconciliation halts trading.
+func (c Config) brokerFeeConfig() FeeConfig {
+	if c.mode() == modeSandbox {
+		return FeeConfig{}
+	}
+	return c.feeConfig()
+}
+
+// brokerReportConfig returns broker report settings: the report is awaited for 5 minutes at most,
+// GetBrokerReport is polled every 10 seconds and the pages are cached in "./broker_reports"
+func (c Config) brokerReportConfig() BrokerReportConfig {
+	brokerReport := c.BrokerReport
+	if brokerReport.DeadlineSeconds <= 0 {
+		brokerReport.DeadlineSeconds = 300
+	}
+	if brokerReport.PollIntervalSeconds <= 0 {
+		brokerReport.PollIntervalSeconds = 10
+	}
+	if brokerReport.CacheDir == "" {
+		brokerReport.CacheDir = "./broker_reports"
+	}
+	return brokerReport
+}
+
+// historyDir returns the directory of the candle store, "./historical_data" by default
+func (c Config) historyDir() string {
+	if c.HistoryDir == "" {
+		return "./historical_data"
+	}
+	return c.HistoryDir
+}
+
+// BenchmarkConfig adds an index from the candle store to the reports, e.g. IMOEX stored by download-history
+type BenchmarkConfig struct {
+	Index    string `yaml:"index"`
+	Interval string `yaml:"interval"`
+}
+
+// benchmarkConfig returns the index benchmark, the index is off by default and its candles are 1m
+func (c Config) benchmarkConfig() BenchmarkConfig {
+	benchmark := c.Benchmark
+	if benchmark.Interval == "" {
+		benchmark.Interval = "1m"
+	}
+	return benchmark
+}
+
+// paperMoney returns the start money of the simulated account, 205 000 RUB by default
+func (c Config) paperMoney() float64 {
+	if c.PaperMoney <= 0 {
+		return 205000
+	}
+	return c.PaperMoney
+}
+
+// investConfig returns the SDK client config
+func investConfig(c Config) investgo.Config {
+	return investgo.Config{
+		EndPoint:                      c.targetAPI() + ":443",
+		Token:                         c.Token,
+		AppName:                       "invest-api-go-sdk",
+		AccountId:                     c.AccountID,
+		DisableResourceExhaustedRetry: false,
+		DisableAllRetry:               false,
+		MaxRetries:                    3,
+	}
+}
+
+// loadConfig reads the config file and returns it with the problems found in it. trading = false is used by the commands
+// that only call the API, they don't need server_port and account_id.
+func loadConfig(path string, trading bool) (Config, []string) {
+	config, err := parseConfig(path)
+	if err != nil {
+		return config, []string{err.Error()}
+	}
+	return config, validateConfig(config, trading, false)
+}
+
+func parseConfig(path string) (Config, error) {
+	var config Config
 	yamlFile, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Cannot read config file: %v", err)
+		return config, fmt.Errorf("Cannot read config file: %v", err)
+	}
+	err = yaml.Unmarshal(yamlFile, &config)
+	if err != nil {
+		return config, fmt.Errorf("Unmarshal: %v", err)
+	}
+	return config, nil
+}
+
+// validateConfig returns the problems of the config. offline = true is used by the commands that work with stored data
+// (backtests, sweeps, reports): they never reach an account, so the mode and its endpoint are not checked.
+func validateConfig(config Config, trading bool, offline bool) []string {
+	var problems []string
+	if config.Token == "" {
+		problems = append(problems, "Config file must contain token")
+	}
+	// the live mode may resolve the account itself
+	if trading && (config.Port == 0 || (config.AccountID == "" && config.mode() != modeLive)) {
+		problems = append(problems, "Config file must contain server_port and account_id")
+	}
+	switch mode := config.mode(); {
+	case offline:
+	case mode == modeSandbox:
+		if !strings.Contains(config.targetAPI(), "sandbox") && config.Mode == "" {
+			// configs written before the modes traded on the production endpoint without a mode
+			problems = append(problems, fmt.Sprintf("Config has no mode and sandbox is the default, but target_api %v is not the sandbox endpoint: "+
+				"add \"mode: live\" to trade real money as before (and arm the run, see Modes) or \"mode: paper\" to simulate the orders", config.targetAPI()))
+		} else if !strings.Contains(config.targetAPI(), "sandbox") {
+			problems = append(problems, fmt.Sprintf("Mode sandbox needs the sandbox endpoint, target_api is %v", config.targetAPI()))
+		}
+	case mode == modeLive:
+		if strings.Contains(config.targetAPI(), "sandbox") {
+			problems = append(problems, fmt.Sprintf("Mode live can't use the sandbox endpoint %v", config.targetAPI()))
+		}
+	case mode == modePaper:
+	default:
+		problems = append(problems, fmt.Sprintf("Unknown mode %q, use sandbox, paper or live", config.Mode))
+	}
+	if fraction := config.Risk.PositionFraction; fraction < 0 || fraction > 1 {
+		problems = append(problems, fmt.Sprintf("risk.position_fraction must be in (0, 1], got %v", fraction))
+	}
+	if config.Risk.MaxDailyLoss < 0 || config.Risk.MaxDailyLossPercent < 0 || config.Risk.MaxTrades < 0 || config.Risk.MaxPositionLots < 0 {
+		problems = append(problems, "risk limits can't be negative")
+	}
+	if confidence := config.Risk.MinConfidence; confidence < 0 || confidence > 1 {
+		problems = append(problems, fmt.Sprintf("risk.min_confidence must be in [0, 1], got %v", confidence))
+	}
+	if config.DataQuality.SpikeSigma < 0 || config.DataQuality.SpikeWindow < 0 || config.DataQuality.MaxGapCandles < 0 {
+		problems = append(problems, "data_quality settings can't be negative")
+	}
+	if interval := config.Benchmark.Interval; interval != "" {
+		if _, ok := candleIntervals[interval]; !ok {
+			problems = append(problems, fmt.Sprintf("Unknown benchmark interval %q", interval))
+		}
+	}
+	problems = append(problems, validateEnsembles(config)...)
+	if rsi := config.rsiConfig(); rsi.Oversold >= rsi.Overbought {
+		problems = append(problems, fmt.Sprintf("rsi_model.oversold %v must be below overbought %v", rsi.Oversold, rsi.Overbought))
+	}
+	for _, name := range config.Shadows {
+		if !config.hasPredictor(name) {
+			problems = append(problems, fmt.Sprintf("Shadow predictor %q is not a predictor", name))
+		}
+	}
+	switch config.FillModel.Price {
+	case "", fillPriceClose, fillPriceNextOpen, fillPriceVWAP:
+	default:
+		problems = append(problems, fmt.Sprintf("Unknown fill_model.price %q, use close, next_open or vwap", config.FillModel.Price))
+	}
+	if fill := config.FillModel; fill.LatencyMs < 0 || fill.MaxParticipation < 0 || fill.MaxParticipation > 1 || fill.SlippageBps < 0 || fill.SpreadFraction < 0 {
+		problems = append(problems, "fill_model settings can't be negative, max_participation is a part of the candle volume")
+	}
+	for ticker, execution := range config.Execution {
+		if execution.Mode != "" && execution.Mode != executionModeMarket && execution.Mode != executionModeLimit {
+			problems = append(problems, fmt.Sprintf("Unknown execution mode %q for %v", execution.Mode, ticker))
+		}
+	}
+	if policy := config.Reconciliation.Policy; policy != "" && policy != reconciliationPolicyReport && policy != reconciliationPolicyCorrect {
+		problems = append(problems, fmt.Sprintf("Unknown reconciliation policy %q", policy))
+	}
+	for _, logConfig := range []LogConfig{config.Logging.Main, config.Logging.Strategy, config.Logging.Events} {
+		if encoding := logConfig.Encoding; encoding != "" && encoding != logEncodingConsole && encoding != logEncodingJSON {
+			problems = append(problems, fmt.Sprintf("Unknown log encoding %q", encoding))
+		}
+		if level := logConfig.Level; level != "" {
+			if _, err := zapcore.ParseLevel(level); err != nil {
+				problems = append(problems, fmt.Sprintf("Unknown log level %q", level))
+			}
+		}
+	}
+	if tariff := config.Fees.Tariff; tariff != "" {
+		if _, ok := tariffPercents[tariff]; !ok {
+			problems = append(problems, fmt.Sprintf("Unknown tariff %q", tariff))
+		}
+	}
+	for name, webhook := range config.Notifications.Webhooks {
+		if webhook.URL == "" {
+			problems = append(problems, fmt.Sprintf("Webhook %q has no url", name))
+		}
+	}
+	return problems
+}
+
+// readConfig returns the config of the trading commands and stops the program if there is a problem in it
+func readConfig(path string) Config {
+	return readModeConfig(path, "")
+}
+
+// readModeConfig is readConfig of a command that trades in the given mode whatever the mode of the config is,
+// the config is checked in that mode
+func readModeConfig(path string, mode string) Config {
+	config, err := parseConfig(path)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if mode != "" {
+		config.Mode = mode
 	}
-	obj := make(map[string]interface{})
-	err = yaml.Unmarshal(yamlFile, obj)
+	if problems := validateConfig(config, true, false); len(problems) != 0 {
+		log.Fatal(strings.Join(problems, "; "))
+	}
+	return config
+}
+
+// readOfflineConfig returns the config of the commands that work with stored data and stops the program if there is
+// a problem in it, the mode and its endpoint are not checked
+func readOfflineConfig(path string) Config {
+	config, err := parseConfig(path)
 	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
+		log.Fatal(err.Error())
 	}
-	return Config{
-		Token:     obj["token"].(string),
-		Port:      obj["server_port"].(int),
-		TargetAPI: obj["target_api"].(string),
-		AccountID: obj["account_id"].(string),
+	if problems := validateConfig(config, true, true); len(problems) != 0 {
+		log.Fatal(strings.Join(problems, "; "))
 	}
+	return config
 }
 
-func getConfigParams() Config {
-	configFilePath := getConfigFilePath()
-	config := readConfig(configFilePath)
+// readClientConfig returns the config of the commands that only call the API
+func readClientConfig(path string) Config {
+	config, problems := loadConfig(path, false)
+	if len(problems) != 0 {
+		log.Fatal(strings.Join(problems, "; "))
+	}
 	return config
 }
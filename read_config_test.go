@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		mode    string
+		offline bool
+		// problem is a part of the expected problem, "" - the config is valid
+		problem string
+	}{
+		{name: "sandbox by default", config: Config{}},
+		{name: "pre-mode production config", config: Config{TargetAPI: liveEndpoint}, problem: `add "mode: live"`},
+		{name: "pre-mode production config in paper", config: Config{TargetAPI: liveEndpoint}, mode: modePaper},
+		{name: "pre-mode production config offline", config: Config{TargetAPI: liveEndpoint}, offline: true},
+		{name: "sandbox on the production endpoint", config: Config{Mode: modeSandbox, TargetAPI: liveEndpoint}, problem: "needs the sandbox endpoint"},
+		{name: "live on the sandbox endpoint", config: Config{Mode: modeLive, TargetAPI: sandboxEndpoint}, problem: "can't use the sandbox endpoint"},
+		{name: "live by default on the production endpoint", config: Config{Mode: modeLive}},
+		{name: "unknown mode", config: Config{Mode: "real"}, problem: "Unknown mode"},
+		{name: "unknown mode offline", config: Config{Mode: "real"}, offline: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Token, config.Port, config.AccountID = "token", 8080, "account"
+			if test.mode != "" {
+				config.Mode = test.mode
+			}
+			problems := strings.Join(validateConfig(config, true, test.offline), "; ")
+			if (test.problem == "") != (problems == "") || !strings.Contains(problems, test.problem) {
+				t.Errorf("problems = %q, want %q", problems, test.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"math"
+	"strings"
+)
+
+// ReconciliationConfig describes the periodic check of the bot positions and money against the account.
+// Policy "report" only logs the drift, policy "correct" takes the broker values.
+// Trading is halted when the drift is bigger than MaxCashDrift RUB or MaxPositionDrift lots, whatever the policy is.
+type ReconciliationConfig struct {
+	IntervalSeconds  int     `yaml:"interval_seconds"`
+	Policy           string  `yaml:"policy"`
+	MaxCashDrift     float64 `yaml:"max_cash_drift"`
+	MaxPositionDrift int64   `yaml:"max_position_drift"`
+}
+
+const (
+	reconciliationPolicyReport  = "report"
+	reconciliationPolicyCorrect = "correct"
+)
+
+// reconcileWithBroker compares the position and money of the strategy with GetPositions/GetPortfolio and returns true
+// if trading has to be halted because of the drift
+func reconcileWithBroker(state *StrategyState, operationsService *investgo.OperationsServiceClient, accId string, config ReconciliationConfig, journal *Journal, logger investgo.Logger) (bool, error) {
+	logger.Infof("Sent reconciliation GetPositions request")
+	positionsResp, err := operationsService.GetPositions(accId)
+	logger.Infof("Got response for reconciliation GetPositions request")
+	if err != nil {
+		logger.Errorf("Can't get positions for reconciliation: %v", err.Error())
+		return false, err
+	}
+	positions, brokerMoney := accountPositions(positionsResp)
+	brokerBalance := positionBalance(positions, state.InstrumentID)
+
+	logger.Infof("Sent reconciliation GetPortfolio request")
+	portfolioResp, err := operationsService.GetPortfolio(accId, pb.PortfolioRequest_RUB)
+	logger.Infof("Got response for reconciliation GetPortfolio request")
+	portfolioQuantity, portfolioTotal := 0.0, 0.0
+	if err != nil {
+		// portfolio is only used for the report, positions are enough to reconcile
+		logger.Errorf("Can't get portfolio for reconciliation: %v", err.Error())
+	} else {
+		for _, position := range portfolioResp.GetPositions() {
+			if position.GetInstrumentUid() == state.InstrumentID {
+				portfolioQuantity = position.GetQuantity().ToFloat()
+			}
+		}
+		portfolioTotal = portfolioResp.GetTotalAmountPortfolio().ToFloat()
+	}
+
+	logger.Infof("Reconciliation: position bot = %v, broker = %v (portfolio quantity = %v), money bot = %v, broker = %v, portfolio total = %v",
+		state.ShareNumber, brokerBalance, portfolioQuantity, state.Stats.money, brokerMoney, portfolioTotal)
+	return reconcileState(state, brokerBalance, brokerMoney, config, journal, logger), nil
+}
+
+// reconcileState journals the drift of the strategy state from the broker position and money, corrects the state by the
+// policy and returns true if trading has to be halted
+func reconcileState(state *StrategyState, brokerBalance int64, brokerMoney float64, config ReconciliationConfig, journal *Journal, logger investgo.Logger) bool {
+	positionDrift := brokerBalance - state.ShareNumber
+	cashDrift := brokerMoney - state.Stats.money
+	journal.record(JournalRecord{
+		Kind:         journalReconciliation,
+		InstrumentID: state.InstrumentID,
+		Balance:      brokerBalance,
+		Money:        brokerMoney,
+		Message:      fmt.Sprintf("position drift = %v, cash drift = %.2f", positionDrift, cashDrift),
+	})
+	if positionDrift == 0 && math.Abs(cashDrift) < 0.01 {
+		return false
+	}
+
+	halt := false
+	if abs(positionDrift) > config.MaxPositionDrift || math.Abs(cashDrift) > config.MaxCashDrift {
+		logger.Errorf("Drift is beyond the threshold: position drift = %v (max %v), cash drift = %.2f (max %v). Halting trading", positionDrift, config.MaxPositionDrift, cashDrift, config.MaxCashDrift)
+		halt = true
+	}
+	if config.Policy == reconciliationPolicyCorrect {
+		logger.Infof("Correcting the strategy state with the broker values")
+		state.ShareNumber = brokerBalance
+		state.ShareNumberBefore = brokerBalance
+		state.Stats.money = brokerMoney
+		state.CanSell = brokerBalance > 0
+		state.CanBuy = !state.CanSell
+	} else {
+		logger.Infof("Drift is only reported: position drift = %v, cash drift = %.2f", positionDrift, cashDrift)
+	}
+	return halt
+}
+
+// rubMoney returns the amount in rubles, 0 if there are no rubles on the account: the money in other currencies can't
+// buy the instrument
+func rubMoney(moneys []*pb.MoneyValue) float64 {
+	for _, money := range moneys {
+		if strings.EqualFold(money.GetCurrency(), "rub") {
+			return money.ToFloat()
+		}
+	}
+	return 0
+}
+
+// positionBalance returns the lots of the instrument among the positions
+func positionBalance(positions []Position, instrumentId string) int64 {
+	for _, pos := range positions {
+		if pos.Id == instrumentId {
+			return pos.Balance
+		}
+	}
+	return 0
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
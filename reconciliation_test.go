@@ -0,0 +1,98 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReconcileAfterRoundTrip runs the strategy over buy and sell cycles with the simulated broker and reconciles its
+// state with the broker after every action: a state that follows the fills never drifts
+func TestReconcileAfterRoundTrip(t *testing.T) {
+	type step struct {
+		action int
+		close  float64
+		volume int64
+		// lots is the position of the strategy after the action
+		lots int64
+		// trades are the closed trades of the day, a SELL filled in parts is one trade
+		trades int
+	}
+	tests := []struct {
+		name  string
+		fill  FillModelConfig
+		steps []step
+	}{
+		{
+			name: "buy, sell, buy, sell",
+			fill: FillModelConfig{Price: fillPriceClose},
+			steps: []step{
+				{action: 1, close: 100, volume: 10000, lots: 997},
+				{action: 2, close: 110, volume: 10000, lots: 0, trades: 1},
+				{action: 1, close: 105, volume: 10000, lots: 1038, trades: 1},
+				{action: 2, close: 100, volume: 10000, lots: 0, trades: 2},
+			},
+		},
+		{
+			name: "partial sell",
+			fill: FillModelConfig{Price: fillPriceClose, MaxParticipation: 0.5},
+			steps: []step{
+				{action: 1, close: 100, volume: 10000, lots: 997},
+				{action: 2, close: 110, volume: 1000, lots: 497},
+				{action: 0, close: 110, volume: 1000, lots: 497},
+				{action: 2, close: 110, volume: 10000, lots: 0, trades: 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := zap.NewNop().Sugar()
+			start := time.Date(2024, 3, 1, 10, 0, 0, 0, moscowLocation())
+			broker := newSimulatedBroker(100000, FeeConfig{Percent: 0.3}, test.fill, time.Minute, true, nil, logger)
+			broker.onCandle("TCSG", RequestToPredict{Datetime: start, Open: 100, High: 100, Low: 100, Close: 100, Volume: 10000})
+			admin := newAdmin(nil)
+			actions := make(chan Signal)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go startStrategy(actions, strategyParams{
+				broker:       broker,
+				accountId:    backtestAccount,
+				instrumentId: "TCSG",
+				fees:         FeeConfig{Percent: 0.3},
+				admin:        admin,
+				logger:       logger,
+			}, &wg)
+			defer func() {
+				actions <- Signal{Action: 4}
+				wg.Wait()
+			}()
+
+			config := ReconciliationConfig{Policy: reconciliationPolicyReport, MaxCashDrift: 1}
+			for i, step := range test.steps {
+				candle := RequestToPredict{Datetime: start.Add(time.Duration(i+1) * time.Minute), Close: step.close, High: step.close, Low: step.close, Open: step.close, Volume: step.volume}
+				broker.onCandle("TCSG", candle)
+				actions <- Signal{Action: step.action, Confidence: 1}
+				reply := admin.send(adminState, time.Minute)
+				if reply.Err != nil {
+					t.Fatalf("step %v: %v", i, reply.Err)
+				}
+				state := *reply.State
+				if state.ShareNumber != step.lots || state.ShareNumberBefore != step.lots {
+					t.Fatalf("step %v: position %v (before %v), want %v", i, state.ShareNumber, state.ShareNumberBefore, step.lots)
+				}
+				if stats := state.Stats; stats.transactionCount != step.trades || stats.successTransactionCount+stats.failedTransactionCount != step.trades {
+					t.Fatalf("step %v: %v trades (%v won, %v lost), want %v", i, stats.transactionCount, stats.successTransactionCount, stats.failedTransactionCount, step.trades)
+				}
+				if state.CanSell != (step.lots > 0) || state.CanBuy != (step.lots == 0) {
+					t.Fatalf("step %v: canBuy = %v, canSell = %v with %v lots", i, state.CanBuy, state.CanSell, step.lots)
+				}
+				positions, money, _ := broker.positions()
+				if reconcileState(&state, positionBalance(positions, "TCSG"), money, config, nil, logger) {
+					t.Fatalf("step %v: reconciliation halts trading: position %v, broker %v, money %v, broker %v",
+						i, state.ShareNumber, positionBalance(positions, "TCSG"), state.Stats.money, money)
+				}
+			}
+		})
+	}
+}
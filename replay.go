@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	errReplayDiverged = errors.New("replay diverged from the recorded session")
+	errReplayEnded    = errors.New("recorded session is over")
+)
+
+// replayBroker answers the calls of the strategy with the recorded responses in their order. A call that differs from
+// the recorded one (another method or arguments) is a divergence, it and every call after it fail.
+type replayBroker struct {
+	// calls are the recorded request and response pairs
+	calls []SessionEvent
+	next  int
+	// truncated says the recording ends in the middle of a day, the calls after its end are not a divergence
+	truncated  bool
+	divergence string
+}
+
+func newReplayBroker(events []SessionEvent) *replayBroker {
+	broker := &replayBroker{}
+	var request *SessionEvent
+	for i := range events {
+		switch events[i].Kind {
+		case sessionBrokerRequest:
+			request = &events[i]
+		case sessionBrokerResponse:
+			// a request without the response was interrupted by the crash
+			if request != nil {
+				broker.calls = append(broker.calls, *request, events[i])
+				request = nil
+			}
+		}
+	}
+	return broker
+}
+
+func describeBrokerCall(event SessionEvent) string {
+	return fmt.Sprintf("%v(%v, %v, %q)", event.Method, event.InstrumentID, event.Quantity, event.Reason)
+}
+
+// take returns the recorded response of the call
+func (b *replayBroker) take(method string, instrumentId string, quantity int64, reason string) (SessionEvent, error) {
+	if b.divergence != "" {
+		return SessionEvent{}, errReplayDiverged
+	}
+	call := SessionEvent{Method: method, InstrumentID: instrumentId, Quantity: quantity, Reason: reason}
+	if b.next >= len(b.calls) {
+		if b.truncated {
+			return SessionEvent{}, errReplayEnded
+		}
+		b.divergence = fmt.Sprintf("broker call %v was not recorded", describeBrokerCall(call))
+		return SessionEvent{}, errReplayDiverged
+	}
+	request, response := b.calls[b.next], b.calls[b.next+1]
+	if describeBrokerCall(request) != describeBrokerCall(call) {
+		b.divergence = fmt.Sprintf("broker call %v: recorded %v, replayed %v", b.next/2+1, describeBrokerCall(request), describeBrokerCall(call))
+		return SessionEvent{}, errReplayDiverged
+	}
+	b.next += 2
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+func (b *replayBroker) order(method string, instrumentId string, quantity int64) (OrderResult, error) {
+	response, err := b.take(method, instrumentId, quantity, "")
+	if response.Result == nil {
+		return OrderResult{}, err
+	}
+	return *response.Result, err
+}
+
+func (b *replayBroker) now() time.Time {
+	response, _ := b.take("now", "", 0, "")
+	if response.Clock == nil {
+		return time.Now()
+	}
+	return *response.Clock
+}
+
+func (b *replayBroker) lastPrice(instrumentId string) (float64, error) {
+	response, err := b.take("lastPrice", instrumentId, 0, "")
+	return response.Price, err
+}
+
+func (b *replayBroker) positions() ([]Position, float64, error) {
+	response, err := b.take("positions", "", 0, "")
+	return response.Positions, response.Money, err
+}
+
+func (b *replayBroker) buy(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.order("buy", instrumentId, quantity)
+}
+
+func (b *replayBroker) sell(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.order("sell", instrumentId, quantity)
+}
+
+func (b *replayBroker) closePosition(instrumentId string, quantity int64) (OrderResult, error) {
+	return b.order("closePosition", instrumentId, quantity)
+}
+
+func (b *replayBroker) cancelOpenOrders(instrumentId string, reason string) (int, error) {
+	response, err := b.take("cancelOpenOrders", instrumentId, 0, reason)
+	return response.Count, err
+}
+
+func (b *replayBroker) pendingOrders() ([]adminOrder, error) {
+	response, err := b.take("pendingOrders", "", 0, "")
+	return response.Orders, err
+}
+
+// replayResult is the recorded and the replayed decisions, Divergence is the first difference of the predictions or the
+// broker calls. Session is the session file written by the replay.
+type replayResult struct {
+	Recorded      []SessionEvent
+	Replayed      []SessionEvent
+	BrokerCalls   int
+	ReplayedCalls int
+	Divergence    string
+	Session       string
+}
+
+// diverged says the replay didn't reproduce the session
+func (r replayResult) diverged() bool {
+	if r.Divergence != "" || len(r.Recorded) != len(r.Replayed) {
+		return true
+	}
+	for i := range r.Recorded {
+		if r.Recorded[i].Action != r.Replayed[i].Action || r.Recorded[i].Outcome != r.Replayed[i].Outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// replaySession runs the strategy over the recorded session: the days start from the recorded states, the fake predictor
+// answers with the recorded responses, the admin commands and the reconciliations come in their recorded order and the
+// broker answers with the recorded responses. The replay is recorded to dir as well.
+func replaySession(events []SessionEvent, dir string, logger *zap.SugaredLogger) (replayResult, error) {
+	var start *SessionEvent
+	for i := range events {
+		if events[i].Kind == sessionStart {
+			start = &events[i]
+			break
+		}
+	}
+	if start == nil || start.Fees == nil || start.Risk == nil {
+		return replayResult{}, errors.New("the session has no start event")
+	}
+	journal, err := openJournal(filepath.Join(dir, "journal"), start.AccountID)
+	if err != nil {
+		return replayResult{}, err
+	}
+	defer journal.Close()
+	recorder, err := openSessionRecorder(dir, start.AccountID, logger)
+	if err != nil {
+		return replayResult{}, err
+	}
+	defer recorder.Close()
+
+	broker := newReplayBroker(events)
+	admin := newAdmin(nil)
+	result := replayResult{BrokerCalls: len(broker.calls) / 2, Session: recorder.path}
+	diverge := func(format string, args ...interface{}) {
+		if result.Divergence == "" {
+			result.Divergence = fmt.Sprintf(format, args...)
+		}
+	}
+
+	var (
+		actions         chan Signal
+		reconciliations chan StrategyState
+		wg              sync.WaitGroup
+		dayOpen         bool
+		predictions     []ResponseAction
+	)
+	for _, event := range events {
+		switch event.Kind {
+		case sessionPredictorResponse:
+			if event.Response != nil {
+				predictions = append(predictions, *event.Response)
+			}
+		case sessionDecision:
+			result.Recorded = append(result.Recorded, event)
+		case sessionDayStart:
+			// the channels are unbuffered: the strategy takes the inputs in the recorded order
+			actions, reconciliations = make(chan Signal), make(chan StrategyState)
+			wg.Add(1)
+			go startStrategy(actions, strategyParams{
+				broker:          newRecordingBroker(broker, recorder),
+				accountId:       start.AccountID,
+				instrumentId:    event.InstrumentID,
+				journal:         journal,
+				fees:            *start.Fees,
+				risk:            *start.Risk,
+				admin:           admin,
+				recorder:        recorder,
+				initialState:    event.State,
+				reconciliations: reconciliations,
+				logger:          logger,
+			}, &wg)
+			dayOpen = true
+		case sessionAction:
+			if !dayOpen {
+				continue
+			}
+			if event.Action == 4 {
+				actions <- Signal{Action: 4}
+				wg.Wait()
+				dayOpen = false
+				continue
+			}
+			signal := Signal{Action: event.Action, Confidence: event.Confidence}
+			if len(predictions) == 0 {
+				diverge("action %v of %v has no predictor response", event.Action, event.Time.Format(time.RFC3339))
+			} else {
+				if predictions[0].Action != event.Action {
+					diverge("action %v of %v, the predictor responded %v", event.Action, event.Time.Format(time.RFC3339), predictions[0].Action)
+				}
+				signal = predictions[0].signal()
+				predictions = predictions[1:]
+			}
+			admin.setPaused(event.Paused)
+			actions <- signal
+			admin.send(adminSync, time.Minute)
+		case sessionAdminCommand:
+			if dayOpen && event.Command != adminSync {
+				admin.send(event.Command, time.Minute)
+			}
+		case sessionReconciliation:
+			if dayOpen && event.State != nil {
+				reconciliations <- *event.State
+			}
+		}
+	}
+	if dayOpen {
+		broker.truncated = true
+		close(actions)
+		wg.Wait()
+	}
+
+	// the strategy goroutines are finished, nothing else touches the broker
+	result.ReplayedCalls = broker.next / 2
+	if broker.divergence != "" {
+		diverge("%v", broker.divergence)
+	} else if broker.next < len(broker.calls) {
+		diverge("%v recorded broker calls were not made, the first is %v", result.BrokerCalls-result.ReplayedCalls, describeBrokerCall(broker.calls[broker.next]))
+	}
+	err = recorder.Close()
+	if err != nil {
+		return replayResult{}, err
+	}
+	replayed, err := readSession(recorder.path)
+	if err != nil {
+		return replayResult{}, err
+	}
+	for _, event := range replayed {
+		if event.Kind == sessionDecision {
+			result.Replayed = append(result.Replayed, event)
+		}
+	}
+	return result, nil
+}
+
+// runReplayCommand runs the strategy over a recorded session with a fake broker and predictor and compares its decisions
+// with the recorded ones. The journal, the log and the session of the replay are written to <out>/<start time>,
+// the command exits with 1 if the replay diverged:
+// ./TradingBot replay -session sessions/<start time>_<account>.session.jsonl [-out ./replays]
+func runReplayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	sessionPath := flags.String("session", "", "session file written by the recorder")
+	out := flags.String("out", "./replays", "directory of the replay results")
+	_ = flags.Parse(args)
+	if *sessionPath == "" {
+		log.Fatalf("-session is required")
+	}
+
+	events, err := readSession(*sessionPath)
+	if err != nil {
+		log.Fatalf("Can't read the session: %v", err)
+	}
+	dir := filepath.Join(*out, time.Now().Format("2006-01-02_150405"))
+	l, err := newLogger(LogConfig{Dir: dir, Level: "info", DisableStderr: true}, "replay", "tradeStats")
+	if err != nil {
+		log.Fatalf("logger creating error %v", err)
+	}
+	logger := l.Sugar()
+	defer logger.Sync()
+
+	result, err := replaySession(events, dir, logger)
+	if err != nil {
+		log.Fatalf("replay error %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\taction\trecorded\treplayed\tmatch")
+	matched := 0
+	for i := 0; i < len(result.Recorded) || i < len(result.Replayed); i++ {
+		action, recorded, replayed := -1, "-", "-"
+		if i < len(result.Recorded) {
+			action, recorded = result.Recorded[i].Action, result.Recorded[i].Outcome
+		}
+		if i < len(result.Replayed) {
+			action, replayed = result.Replayed[i].Action, result.Replayed[i].Outcome
+		}
+		match := i < len(result.Recorded) && i < len(result.Replayed) && result.Recorded[i].Action == result.Replayed[i].Action && recorded == replayed
+		if match {
+			matched++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", i+1, action, recorded, replayed, match)
+	}
+	w.Flush()
+
+	fmt.Printf("decisions\t%v\n", len(result.Recorded))
+	fmt.Printf("matched\t%v\n", matched)
+	fmt.Printf("broker_calls\t%v\n", result.BrokerCalls)
+	fmt.Printf("replayed_broker_calls\t%v\n", result.ReplayedCalls)
+	if result.Divergence != "" {
+		fmt.Printf("divergence\t%v\n", result.Divergence)
+	}
+	fmt.Printf("results\t%v\n", dir)
+	if result.diverged() {
+		fmt.Println("replay diverged from the session")
+		os.Exit(1)
+	}
+	fmt.Println("replay reproduced the session")
+}
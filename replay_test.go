@@ -0,0 +1,131 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordSession records a day of the strategy with the simulated broker the way the main loop does and returns its events
+func recordSession(t *testing.T, candles []RequestToPredict, responses []ResponseAction) []SessionEvent {
+	logger := zap.NewNop().Sugar()
+	dir := t.TempDir()
+	fees, risk := FeeConfig{Percent: 0.3}, RiskConfig{}
+	journal, err := openJournal(filepath.Join(dir, "journal"), "account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	recorder, err := openSessionRecorder(dir, "account", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.record(SessionEvent{Kind: sessionStart, AccountID: "account", InstrumentID: "TCSG", Fees: &fees, Risk: &risk})
+
+	simulated := newSimulatedBroker(100000, fees, FillModelConfig{Price: fillPriceClose}, time.Minute, true, nil, logger)
+	simulated.onCandle("TCSG", candles[0])
+	admin := newAdmin(nil)
+	actions := make(chan Signal)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go startStrategy(actions, strategyParams{
+		broker:       newRecordingBroker(simulated, recorder),
+		accountId:    "account",
+		instrumentId: "TCSG",
+		journal:      journal,
+		fees:         fees,
+		risk:         risk,
+		admin:        admin,
+		recorder:     recorder,
+		logger:       logger,
+	}, &wg)
+	for i, candle := range candles {
+		simulated.onCandle("TCSG", candle)
+		recorder.record(SessionEvent{Kind: sessionCandle, InstrumentID: "TCSG", Candle: &candles[i]})
+		recorder.record(SessionEvent{Kind: sessionPredictorRequest, Candle: &candles[i]})
+		recorder.record(SessionEvent{Kind: sessionPredictorResponse, Response: &responses[i]})
+		actions <- responses[i].signal()
+		// the next candle comes after the strategy has processed the action, as a minute later in the main loop
+		admin.send(adminSync, time.Minute)
+	}
+	actions <- Signal{Action: 4}
+	wg.Wait()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := readSession(recorder.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestReplaySession(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, moscowLocation())
+	var candles []RequestToPredict
+	for i, close := range []float64{100, 102, 105, 103, 101, 104} {
+		candles = append(candles, RequestToPredict{ReqId: uint64(i + 1), Datetime: start.Add(time.Duration(i) * time.Minute),
+			Open: close, High: close, Low: close, Close: close, Volume: 10000})
+	}
+	responses := []ResponseAction{{Action: 1}, {Action: 0}, {Action: 2}, {Action: 1}, {Action: 1}, {Action: 2}}
+	for i := range responses {
+		responses[i].RespId = int(candles[i].ReqId)
+	}
+
+	tests := []struct {
+		name string
+		// tamper changes the recorded session
+		tamper   func(events []SessionEvent)
+		diverged bool
+	}{
+		{name: "the recorded session is reproduced"},
+		{
+			name: "a changed fill of the broker",
+			tamper: func(events []SessionEvent) {
+				for i := range events {
+					if events[i].Kind == sessionBrokerResponse && events[i].Method == "buy" {
+						result := *events[i].Result
+						result.LotsExecuted--
+						events[i].Result = &result
+						return
+					}
+				}
+			},
+			diverged: true,
+		},
+		{
+			name: "a changed predictor response",
+			tamper: func(events []SessionEvent) {
+				for i := range events {
+					if events[i].Kind == sessionPredictorResponse {
+						response := *events[i].Response
+						response.Action = 2
+						events[i].Response = &response
+						return
+					}
+				}
+			},
+			diverged: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := recordSession(t, candles, responses)
+			if test.tamper != nil {
+				test.tamper(events)
+			}
+			result, err := replaySession(events, t.TempDir(), zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Recorded) == 0 || result.BrokerCalls == 0 {
+				t.Fatalf("the session has %v decisions and %v broker calls", len(result.Recorded), result.BrokerCalls)
+			}
+			if result.diverged() != test.diverged {
+				t.Errorf("diverged = %v, want %v: %v\nrecorded %+v\nreplayed %+v", result.diverged(), test.diverged, result.Divergence, result.Recorded, result.Replayed)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// DailyReport is the machine-readable summary of one trading day built from the trade journal
+type DailyReport struct {
+	Date         string            `json:"date"`
+	AccountID    string            `json:"account_id"`
+	InstrumentID string            `json:"instrument_id"`
+	Summary      reportSummary     `json:"summary"`
+	Trades       []reportTrade     `json:"trades"`
+	Equity       []reportEquity    `json:"equity"`
+	Signals      map[string]int    `json:"signals"`
+	Benchmarks   []reportBenchmark `json:"benchmarks"`
+}
+
+// reportSummary is analytics.Summary with the units used in the reports: percents, RUB and minutes.
+// ProfitFactor is null when there were no losing trades.
+type reportSummary struct {
+	Trades                int      `json:"trades"`
+	NetPnL                float64  `json:"net_pnl"`
+	Fees                  float64  `json:"fees"`
+	WinRatePercent        float64  `json:"win_rate_percent"`
+	ProfitFactor          *float64 `json:"profit_factor"`
+	Expectancy            float64  `json:"expectancy"`
+	MaxDrawdown           float64  `json:"max_drawdown"`
+	MaxDrawdownPercent    float64  `json:"max_drawdown_percent"`
+	Sharpe                float64  `json:"sharpe"`
+	Sortino               float64  `json:"sortino"`
+	ExposurePercent       float64  `json:"exposure_percent"`
+	AverageHoldingMinutes float64  `json:"average_holding_minutes"`
+	StartEquity           float64  `json:"start_equity"`
+	EndEquity             float64  `json:"end_equity"`
+	ReturnPercent         float64  `json:"return_percent"`
+}
+
+// reportBenchmark compares the day with a benchmark: buy-and-hold of the instrument or the index of the config.
+// Equity is the benchmark scaled to the start equity of the day.
+type reportBenchmark struct {
+	Name                string         `json:"name"`
+	ReturnPercent       float64        `json:"return_percent"`
+	ExcessReturnPercent float64        `json:"excess_return_percent"`
+	Alpha               float64        `json:"alpha"`
+	Beta                float64        `json:"beta"`
+	Correlation         float64        `json:"correlation"`
+	Equity              []reportEquity `json:"equity"`
+}
+
+type reportTrade struct {
+	EntryTime      time.Time `json:"entry_time"`
+	ExitTime       time.Time `json:"exit_time"`
+	Lots           int64     `json:"lots"`
+	EntryAmount    float64   `json:"entry_amount"`
+	ExitAmount     float64   `json:"exit_amount"`
+	Fees           float64   `json:"fees"`
+	NetPnL         float64   `json:"net_pnl"`
+	ReturnPercent  float64   `json:"return_percent"`
+	HoldingMinutes float64   `json:"holding_minutes"`
+}
+
+type reportEquity struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// journalInstrument returns the instrument the bot traded according to the signals of the journal
+func journalInstrument(records []JournalRecord) string {
+	for _, rec := range records {
+		if rec.Kind == journalSignal && rec.InstrumentID != "" {
+			return rec.InstrumentID
+		}
+	}
+	for _, rec := range records {
+		if rec.Kind == journalFill && rec.InstrumentID != "" {
+			return rec.InstrumentID
+		}
+	}
+	return ""
+}
+
+// newDailyReport builds the report of the day, the equity is compared with buy-and-hold of the instrument and with the index.
+// periodsPerYear annualizes the ratios of the equity curve
+func newDailyReport(date time.Time, accountId string, instrumentId string, records []JournalRecord, index benchmarkCurve, periodsPerYear float64) DailyReport {
+	summary, trades, equity := performanceFromJournal(records, instrumentId, periodsPerYear)
+	report := DailyReport{
+		Date:         date.Format("2006-01-02"),
+		AccountID:    accountId,
+		InstrumentID: instrumentId,
+		Summary: reportSummary{
+			Trades:                summary.Trades,
+			NetPnL:                round2(summary.NetPnL),
+			Fees:                  round2(summary.Fees),
+			WinRatePercent:        round2(summary.WinRate * 100),
+			Expectancy:            round2(summary.Expectancy),
+			MaxDrawdown:           round2(summary.MaxDrawdown),
+			MaxDrawdownPercent:    round2(summary.MaxDrawdownPercent * 100),
+			Sharpe:                round2(summary.Sharpe),
+			Sortino:               round2(summary.Sortino),
+			ExposurePercent:       round2(summary.Exposure * 100),
+			AverageHoldingMinutes: round2(summary.AverageHolding.Minutes()),
+		},
+		Trades:     make([]reportTrade, 0, len(trades)),
+		Equity:     make([]reportEquity, 0, len(equity)),
+		Signals:    make(map[string]int),
+		Benchmarks: make([]reportBenchmark, 0),
+	}
+	if !math.IsInf(summary.ProfitFactor, 1) {
+		profitFactor := round2(summary.ProfitFactor)
+		report.Summary.ProfitFactor = &profitFactor
+	}
+	if len(equity) > 0 {
+		report.Summary.StartEquity = round2(equity[0].Equity)
+		report.Summary.EndEquity = round2(equity[len(equity)-1].Equity)
+		if equity[0].Equity != 0 {
+			report.Summary.ReturnPercent = round2((equity[len(equity)-1].Equity/equity[0].Equity - 1) * 100)
+		}
+	}
+	for _, benchmark := range compareBenchmarks(records, instrumentId, equity, periodsPerYear, index) {
+		reported := reportBenchmark{
+			Name:                benchmark.Name,
+			ReturnPercent:       round2(benchmark.Stats.Return * 100),
+			ExcessReturnPercent: round2(benchmark.Stats.ExcessReturn * 100),
+			Alpha:               round4(benchmark.Stats.Alpha),
+			Beta:                round4(benchmark.Stats.Beta),
+			Correlation:         round4(benchmark.Stats.Correlation),
+		}
+		for _, point := range benchmark.Curve {
+			reported.Equity = append(reported.Equity, reportEquity{Time: point.Time, Equity: round2(point.Equity)})
+		}
+		report.Benchmarks = append(report.Benchmarks, reported)
+	}
+	for _, trade := range trades {
+		report.Trades = append(report.Trades, reportTrade{
+			EntryTime:      trade.EntryTime,
+			ExitTime:       trade.ExitTime,
+			Lots:           trade.Lots,
+			EntryAmount:    round2(trade.EntryAmount),
+			ExitAmount:     round2(trade.ExitAmount),
+			Fees:           round2(trade.Fees),
+			NetPnL:         round2(trade.NetPnL()),
+			ReturnPercent:  round2(trade.Return() * 100),
+			HoldingMinutes: round2(trade.Holding().Minutes()),
+		})
+	}
+	for _, point := range equity {
+		report.Equity = append(report.Equity, reportEquity{Time: point.Time, Equity: point.Equity})
+	}
+	actionNames := map[int]string{0: "HOLD", 1: "BUY", 2: "SELL"}
+	for _, rec := range records {
+		if rec.Kind == journalSignal && rec.InstrumentID == instrumentId {
+			report.Signals[actionNames[rec.Action]] += 1
+		}
+	}
+	return report
+}
+
+// writeDailyReport writes <dir>/<date>_<account>.json, _trades.csv, _summary.csv and .html and returns their paths
+func writeDailyReport(dir string, report DailyReport) ([]string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%s_%s", report.Date, report.AccountID))
+	files := []string{base + ".json", base + "_trades.csv", base + "_summary.csv", base + ".html"}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(files[0], data, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = writeCSV(files[1], reportTradeRows(report))
+	if err != nil {
+		return nil, err
+	}
+	err = writeCSV(files[2], reportSummaryRows(report))
+	if err != nil {
+		return nil, err
+	}
+	err = writeReportHTML(files[3], report)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func writeCSV(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	err = writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func reportTradeRows(report DailyReport) [][]string {
+	rows := [][]string{{"entry_time", "exit_time", "lots", "entry_amount", "exit_amount", "fees", "net_pnl", "return_percent", "holding_minutes"}}
+	for _, trade := range report.Trades {
+		rows = append(rows, []string{
+			trade.EntryTime.Format(time.RFC3339),
+			trade.ExitTime.Format(time.RFC3339),
+			strconv.FormatInt(trade.Lots, 10),
+			formatFloat(trade.EntryAmount),
+			formatFloat(trade.ExitAmount),
+			formatFloat(trade.Fees),
+			formatFloat(trade.NetPnL),
+			formatFloat(trade.ReturnPercent),
+			formatFloat(trade.HoldingMinutes),
+		})
+	}
+	return rows
+}
+
+func reportSummaryRows(report DailyReport) [][]string {
+	summary := report.Summary
+	profitFactor := "inf"
+	if summary.ProfitFactor != nil {
+		profitFactor = formatFloat(*summary.ProfitFactor)
+	}
+	rows := [][]string{
+		{"metric", "value"},
+		{"date", report.Date},
+		{"account_id", report.AccountID},
+		{"instrument_id", report.InstrumentID},
+		{"trades", strconv.Itoa(summary.Trades)},
+		{"net_pnl", formatFloat(summary.NetPnL)},
+		{"fees", formatFloat(summary.Fees)},
+		{"win_rate_percent", formatFloat(summary.WinRatePercent)},
+		{"profit_factor", profitFactor},
+		{"expectancy", formatFloat(summary.Expectancy)},
+		{"max_drawdown", formatFloat(summary.MaxDrawdown)},
+		{"max_drawdown_percent", formatFloat(summary.MaxDrawdownPercent)},
+		{"sharpe", formatFloat(summary.Sharpe)},
+		{"sortino", formatFloat(summary.Sortino)},
+		{"exposure_percent", formatFloat(summary.ExposurePercent)},
+		{"average_holding_minutes", formatFloat(summary.AverageHoldingMinutes)},
+		{"start_equity", formatFloat(summary.StartEquity)},
+		{"end_equity", formatFloat(summary.EndEquity)},
+		{"return_percent", formatFloat(summary.ReturnPercent)},
+	}
+	for _, benchmark := range report.Benchmarks {
+		rows = append(rows,
+			[]string{benchmark.Name + "_return_percent", formatFloat(benchmark.ReturnPercent)},
+			[]string{benchmark.Name + "_excess_return_percent", formatFloat(benchmark.ExcessReturnPercent)},
+			[]string{benchmark.Name + "_alpha", formatFloat(benchmark.Alpha)},
+			[]string{benchmark.Name + "_beta", formatFloat(benchmark.Beta)},
+			[]string{benchmark.Name + "_correlation", formatFloat(benchmark.Correlation)},
+		)
+	}
+	return rows
+}
+
+const reportHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Trading report {{.Report.Date}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th { background: #eee; }
+.loss { color: #c00; }
+.profit { color: #080; }
+</style>
+</head>
+<body>
+<h1>Trading report {{.Report.Date}}</h1>
+<p>Account {{.Report.AccountID}}, instrument {{.Report.InstrumentID}}</p>
+<h2>Summary</h2>
+<table>
+{{range .Summary}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+<h2>Equity</h2>
+{{if .Points}}<svg width="{{.Width}}" height="{{.Height}}" style="border: 1px solid #ccc">
+<polyline fill="none" stroke="#36c" stroke-width="1.5" points="{{.Points}}"/>
+{{range .Benchmarks}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1" stroke-dasharray="4 2" points="{{.Points}}"/>
+{{end}}<text x="4" y="14" font-size="12">{{.MaxEquity}}</text>
+<text x="4" y="{{.Height}}" dy="-4" font-size="12">{{.MinEquity}}</text>
+</svg>
+<p><span style="color: #36c">equity</span>{{range .Benchmarks}}, <span style="color: {{.Color}}">{{.Name}}</span>{{end}}</p>{{else}}<p>No equity points</p>{{end}}
+<h2>Trades</h2>
+<table>
+<tr>{{range index .Trades 0}}<th>{{.}}</th>{{end}}</tr>
+{{range $i, $row := .Trades}}{{if $i}}<tr class="{{if lt (index $.PnL $i) 0.0}}loss{{else}}profit{{end}}">{{range $row}}<td>{{.}}</td>{{end}}</tr>
+{{end}}{{end}}</table>
+</body>
+</html>
+`
+
+var reportHTML = template.Must(template.New("report").Parse(reportHTMLTemplate))
+
+// benchmarkColors are the colors of the benchmark curves of the HTML report
+var benchmarkColors = []string{"#c60", "#393", "#939"}
+
+func writeReportHTML(path string, report DailyReport) error {
+	const width, height = 900.0, 300.0
+	// the benchmarks are drawn on the scale of the equity
+	minEquity, maxEquity := math.Inf(1), math.Inf(-1)
+	curves := [][]reportEquity{report.Equity}
+	for _, benchmark := range report.Benchmarks {
+		curves = append(curves, benchmark.Equity)
+	}
+	for _, curve := range curves {
+		for _, point := range curve {
+			minEquity = math.Min(minEquity, point.Equity)
+			maxEquity = math.Max(maxEquity, point.Equity)
+		}
+	}
+	polyline := func(curve []reportEquity) string {
+		points := ""
+		if len(report.Equity) > 1 {
+			from, to := report.Equity[0].Time, report.Equity[len(report.Equity)-1].Time
+			span := maxEquity - minEquity
+			if span == 0 {
+				span = 1
+			}
+			for _, point := range curve {
+				x := width * float64(point.Time.Sub(from)) / float64(to.Sub(from))
+				y := height - height*(point.Equity-minEquity)/span
+				points += fmt.Sprintf("%.1f,%.1f ", x, y)
+			}
+		}
+		return points
+	}
+	points := polyline(report.Equity)
+	var benchmarks []map[string]string
+	for i, benchmark := range report.Benchmarks {
+		benchmarks = append(benchmarks, map[string]string{"Name": benchmark.Name, "Color": benchmarkColors[i%len(benchmarkColors)], "Points": polyline(benchmark.Equity)})
+	}
+	// PnL of the trade row is used to color it, the header row gets a dummy value
+	pnl := []float64{0}
+	for _, trade := range report.Trades {
+		pnl = append(pnl, trade.NetPnL)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = reportHTML.Execute(file, map[string]interface{}{
+		"Report":     report,
+		"Summary":    reportSummaryRows(report)[1:],
+		"Trades":     reportTradeRows(report),
+		"PnL":        pnl,
+		"Points":     points,
+		"Benchmarks": benchmarks,
+		"Width":      width,
+		"Height":     height,
+		"MinEquity":  formatFloat(round2(minEquity)),
+		"MaxEquity":  formatFloat(round2(maxEquity)),
+	})
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func round4(f float64) float64 {
+	return math.Round(f*10000) / 10000
+}
+
+// generateDailyReport builds the report of the day from the journal of the minute candles and writes all its files
+func generateDailyReport(journalDir string, reportsDir string, accountId string, date time.Time, index benchmarkCurve) ([]string, error) {
+	records, err := readJournal(journalDir, accountId, date)
+	if err != nil {
+		return nil, err
+	}
+	report := newDailyReport(date, accountId, journalInstrument(records), records, index, minutesPerTradingYear)
+	return writeDailyReport(reportsDir, report)
+}
+
+// runReportCommand builds the report of a past day from the stored journal:
+// ./TradingBot report -config <path to config file> -date 2006-01-02 [-account paper_<account id>]
+func runReportCommand(args []string) {
+	flags, configFilePath := commandFlags("report")
+	dateString := flags.String("date", time.Now().Format("2006-01-02"), "day of the report, YYYY-MM-DD")
+	account := flags.String("account", "", "account of the journal, account_id of the config by default")
+	_ = flags.Parse(args)
+
+	config := readOfflineConfig(*configFilePath)
+	date := parseDate("date", *dateString)
+	if *account == "" {
+		*account = config.AccountID
+	}
+	// the report is built without the index if its candles of the day are not stored
+	index, err := loadIndexBenchmark(config.historyDir(), config.benchmarkConfig(), date, date.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("Index benchmark is skipped: %v", err)
+	}
+	files, err := generateDailyReport(config.journalDir(), config.reportsDir(), *account, date, index)
+	if err != nil {
+		log.Fatalf("Cannot generate report: %v", err)
+	}
+	for _, file := range files {
+		fmt.Println(file)
+	}
+}
@@ -13,11 +13,32 @@ type RequestToPredict struct {
 	Volume   int64     `json:"Volume"`
 }
 
-// ResponseAction gets returned from the Python server: Action = 0 - HOLD, Action = 1 - BUY, Action = 2 - SELL
+// ResponseAction gets returned from the Python server: Action = 0 - HOLD, Action = 1 - BUY, Action = 2 - SELL.
+// Probabilities of HOLD, BUY and SELL and ModelVersion are optional, a server sending only Action is fully confident.
 type ResponseAction struct {
-	RespId int    `json:"RespId"`
-	Action int    `json:"Action"`
-	Error  string `json:"Error"`
+	RespId        int       `json:"RespId"`
+	Action        int       `json:"Action"`
+	Probabilities []float64 `json:"Probabilities,omitempty"`
+	ModelVersion  string    `json:"ModelVersion,omitempty"`
+	Error         string    `json:"Error"`
+}
+
+// confidence returns the probability of the action, 1 without probabilities
+func (r ResponseAction) confidence() float64 {
+	if r.Action < 0 || r.Action >= len(r.Probabilities) {
+		return 1
+	}
+	return r.Probabilities[r.Action]
+}
+
+func (r ResponseAction) signal() Signal {
+	return Signal{Action: r.Action, Confidence: r.confidence()}
+}
+
+// Signal is what startStrategy gets from the main loop: the action with the confidence of the predictor, action 4 ends the day
+type Signal struct {
+	Action     int
+	Confidence float64
 }
 
 type Position struct {
@@ -42,4 +63,8 @@ type TradingStatistics struct {
 	minimumMoney            float64
 	totalPercentProfit      float64
 	totalGain               float64
+	commission              float64
+	// soldLots and soldAmount (after the commission) are of the partial sells of the open trade, it is counted when closed
+	soldLots   int64
+	soldAmount float64
 }
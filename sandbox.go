@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"go.uber.org/zap"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sandbox account commands. The sandbox service is only needed to manage the sandbox accounts and pay money in,
+// trading on them goes through the usual services with the sandbox endpoint in target_api.
+// The output is tab-separated, so the commands can be used in scripts, e.g. a fresh account before every test day:
+// ACCOUNT=$(./TradingBot sandbox reset -config config.yaml -amount 205000)
+
+// sandboxCommand parses the common flags of the sandbox commands and connects to the sandbox endpoint whatever the mode
+// and target_api of the config are. The account is the -account flag or, if fromConfig is set, account_id of a sandbox
+// config; required says the command can't work without it. The commands closing an account only take -account,
+// so a mistyped config never closes the account it trades on.
+func sandboxCommand(name string, args []string, required bool, fromConfig bool, extra func(flags *flag.FlagSet)) (*investgo.SandboxServiceClient, string, *zap.SugaredLogger, func()) {
+	flags, configFilePath := commandFlags(name)
+	usage := "sandbox account id"
+	if fromConfig {
+		usage = "sandbox account id, account_id of a sandbox config by default"
+	}
+	accountId := flags.String("account", "", usage)
+	if extra != nil {
+		extra(flags)
+	}
+	_ = flags.Parse(args)
+
+	configParams := readSandboxConfig(*configFilePath)
+	if *accountId == "" && fromConfig {
+		*accountId = configParams.AccountID
+	}
+	if required && *accountId == "" {
+		if fromConfig {
+			log.Fatalf("Set the sandbox account: -account <id> or account_id in a sandbox config")
+		}
+		log.Fatalf("Set the sandbox account: -account <id>")
+	}
+	logger := commandLogger()
+	client, closeClient := commandClient(configParams, logger)
+	return client.NewSandboxServiceClient(), *accountId, logger, closeClient
+}
+
+// readSandboxConfig returns the config of the sandbox commands with the sandbox endpoint. account_id is kept only if
+// the config is a sandbox one, the account of a live or paper config is a real account.
+func readSandboxConfig(path string) Config {
+	configParams, err := parseConfig(path)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if configParams.mode() != modeSandbox || !strings.Contains(configParams.targetAPI(), "sandbox") {
+		configParams.AccountID = ""
+	}
+	configParams.Mode, configParams.TargetAPI = modeSandbox, sandboxEndpoint
+	if problems := validateConfig(configParams, false, false); len(problems) != 0 {
+		log.Fatal(strings.Join(problems, "; "))
+	}
+	return configParams
+}
+
+// runSandboxListCommand prints the sandbox accounts of the token:
+// ./TradingBot sandbox list -config <path to config file>
+func runSandboxListCommand(args []string) {
+	sandboxService, _, logger, closeClient := sandboxCommand("sandbox list", args, false, false, nil)
+	defer closeClient()
+
+	logger.Infof("Sent GetSandboxAccounts request")
+	accountsResp, err := sandboxService.GetSandboxAccounts()
+	logger.Infof("Got response for GetSandboxAccounts request")
+	if err != nil {
+		logger.Fatalf("Can't get sandbox accounts: %v", err.Error())
+	}
+	rows := [][]string{{"id", "status", "opened", "name"}}
+	for _, account := range accountsResp.GetAccounts() {
+		rows = append(rows, []string{account.GetId(), account.GetStatus().String(), account.GetOpenedDate().AsTime().Format("2006-01-02"), account.GetName()})
+	}
+	printRows(rows)
+}
+
+// runSandboxOpenCommand opens a sandbox account and prints its id:
+// ./TradingBot sandbox open -config <path to config file>
+func runSandboxOpenCommand(args []string) {
+	flags, configFilePath := commandFlags("sandbox open")
+	_ = flags.Parse(args)
+
+	configParams := readSandboxConfig(*configFilePath)
+	logger := commandLogger()
+	client, closeClient := commandClient(configParams, logger)
+	defer closeClient()
+
+	accId, err := createAccountId(client, client.NewSandboxServiceClient(), logger)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(accId)
+}
+
+// runSandboxDepositCommand pays the amount in to the sandbox account and prints the balance of the currency:
+// ./TradingBot sandbox deposit -config <path to config file> -amount 205000 [-currency RUB] [-account <id>]
+func runSandboxDepositCommand(args []string) {
+	var (
+		amount   *float64
+		currency *string
+	)
+	sandboxService, accountId, logger, closeClient := sandboxCommand("sandbox deposit", args, true, true, func(flags *flag.FlagSet) {
+		amount = flags.Float64("amount", 205000, "amount to pay in")
+		currency = flags.String("currency", "RUB", "currency of the amount")
+	})
+	defer closeClient()
+
+	balance, err := depositMoney(sandboxService, accountId, *amount, *currency, logger)
+	if err != nil {
+		os.Exit(1)
+	}
+	printRows([][]string{{accountId, *currency, strconv.FormatFloat(balance, 'f', 2, 64)}})
+}
+
+// runSandboxPositionsCommand prints the money and the securities of the sandbox account:
+// ./TradingBot sandbox positions -config <path to config file> [-account <id>]
+func runSandboxPositionsCommand(args []string) {
+	sandboxService, accountId, logger, closeClient := sandboxCommand("sandbox positions", args, true, true, nil)
+	defer closeClient()
+
+	logger.Infof("Sent GetSandboxPositions request")
+	positionsResp, err := sandboxService.GetSandboxPositions(accountId)
+	logger.Infof("Got response for GetSandboxPositions request")
+	if err != nil {
+		logger.Fatalf("Can't get sandbox positions: %v", err.Error())
+	}
+	rows := [][]string{{"kind", "id", "type", "balance", "blocked"}}
+	blocked := make(map[string]float64)
+	for _, money := range positionsResp.GetBlocked() {
+		blocked[money.GetCurrency()] = money.ToFloat()
+	}
+	for _, money := range positionsResp.GetMoney() {
+		rows = append(rows, []string{"money", money.GetCurrency(), "currency", strconv.FormatFloat(money.ToFloat(), 'f', 2, 64), strconv.FormatFloat(blocked[money.GetCurrency()], 'f', 2, 64)})
+	}
+	for _, security := range positionsResp.GetSecurities() {
+		rows = append(rows, []string{"security", security.GetInstrumentUid(), security.GetInstrumentType(), strconv.FormatInt(security.GetBalance(), 10), strconv.FormatInt(security.GetBlocked(), 10)})
+	}
+	printRows(rows)
+}
+
+// runSandboxCloseCommand closes the sandbox account:
+// ./TradingBot sandbox close -config <path to config file> -account <id>
+func runSandboxCloseCommand(args []string) {
+	sandboxService, accountId, logger, closeClient := sandboxCommand("sandbox close", args, true, false, nil)
+	defer closeClient()
+
+	err := closeSandboxAccount(sandboxService, accountId, logger)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(accountId)
+}
+
+// runSandboxResetCommand closes the sandbox account given by -account, opens a new one, pays the amount in and prints
+// the new account id.
+// The sandbox can't withdraw money or sell positions for free, so a new account is the only clean state:
+// ./TradingBot sandbox reset -config <path to config file> -amount 205000 [-currency RUB] [-account <id>]
+func runSandboxResetCommand(args []string) {
+	var (
+		amount   *float64
+		currency *string
+	)
+	sandboxService, accountId, logger, closeClient := sandboxCommand("sandbox reset", args, false, false, func(flags *flag.FlagSet) {
+		amount = flags.Float64("amount", 205000, "amount to pay in to the new account")
+		currency = flags.String("currency", "RUB", "currency of the amount")
+	})
+	defer closeClient()
+
+	if accountId != "" {
+		err := closeSandboxAccount(sandboxService, accountId, logger)
+		if err != nil {
+			os.Exit(1)
+		}
+	}
+	logger.Infof("Sent OpenSandboxAccount request")
+	openAccount, err := sandboxService.OpenSandboxAccount()
+	logger.Infof("Got response for OpenSandboxAccount request")
+	if err != nil {
+		logger.Fatalf("Can't open sandbox account: %v", err.Error())
+	}
+	newAccId := openAccount.GetAccountId()
+	_, err = depositMoney(sandboxService, newAccId, *amount, *currency, logger)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(newAccId)
+}
+
+func closeSandboxAccount(sandboxService *investgo.SandboxServiceClient, accountId string, logger investgo.Logger) error {
+	logger.Infof("Sent CloseSandboxAccount request")
+	_, err := sandboxService.CloseSandboxAccount(accountId)
+	logger.Infof("Got response for CloseSandboxAccount request")
+	if err != nil {
+		logger.Errorf("Can't close sandbox account: %v", err.Error())
+	}
+	return err
+}
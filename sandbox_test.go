@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSandboxConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		account string
+	}{
+		{name: "sandbox config keeps its account", yaml: "token: t\naccount_id: sandbox-1\n", account: "sandbox-1"},
+		{name: "live config", yaml: "token: t\nmode: live\naccount_id: real-1\n"},
+		{name: "paper config", yaml: "token: t\nmode: paper\naccount_id: real-1\n"},
+		{name: "pre-mode production config", yaml: "token: t\ntarget_api: " + liveEndpoint + "\naccount_id: real-1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config := readSandboxConfig(path)
+			if config.targetAPI() != sandboxEndpoint || config.AccountID != test.account {
+				t.Errorf("endpoint %v, account %q, want %v, %q", config.targetAPI(), config.AccountID, sandboxEndpoint, test.account)
+			}
+		})
+	}
+}
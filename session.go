@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RecordingConfig turns the session recorder on: every candle, predictor request and response, strategy input,
+// broker call and decision of a run is written to <Dir>/<start time>_<account>.session.jsonl, "replay" runs the
+// strategy over the file again
+type RecordingConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+// recordingConfig returns the recorder settings, the sessions are written to "./sessions" by default
+func (c Config) recordingConfig() RecordingConfig {
+	recording := c.Recording
+	if recording.Dir == "" {
+		recording.Dir = "./sessions"
+	}
+	return recording
+}
+
+// Kinds of the session events
+const (
+	// sessionStart is the first event, it keeps the account, the instrument and the settings the strategy used
+	sessionStart             = "start"
+	sessionCandle            = "candle"
+	sessionPredictorRequest  = "predictor_request"
+	sessionPredictorResponse = "predictor_response"
+	// sessionDayStart is written by startStrategy, the state is kept if it was restored from the checkpoint
+	sessionDayStart       = "day_start"
+	sessionAction         = "action"
+	sessionAdminCommand   = "admin_command"
+	sessionReconciliation = "reconciliation"
+	sessionBrokerRequest  = "broker_request"
+	sessionBrokerResponse = "broker_response"
+	sessionDecision       = "decision"
+)
+
+// SessionEvent is one line of the session file, only the fields of its kind are set
+type SessionEvent struct {
+	Time         time.Time         `json:"time"`
+	Kind         string            `json:"kind"`
+	AccountID    string            `json:"account_id,omitempty"`
+	InstrumentID string            `json:"instrument_id,omitempty"`
+	Fees         *FeeConfig        `json:"fees,omitempty"`
+	Risk         *RiskConfig       `json:"risk,omitempty"`
+	Candle       *RequestToPredict `json:"candle,omitempty"`
+	Response     *ResponseAction   `json:"response,omitempty"`
+	State        *StrategyState    `json:"state,omitempty"`
+	Action       int               `json:"action,omitempty"`
+	Confidence   float64           `json:"confidence,omitempty"`
+	Paused       bool              `json:"paused,omitempty"`
+	Command      string            `json:"command,omitempty"`
+	Method       string            `json:"method,omitempty"`
+	Quantity     int64             `json:"quantity,omitempty"`
+	Reason       string            `json:"reason,omitempty"`
+	Clock        *time.Time        `json:"clock,omitempty"`
+	Result       *OrderResult      `json:"result,omitempty"`
+	Positions    []Position        `json:"positions,omitempty"`
+	Money        float64           `json:"money,omitempty"`
+	Price        float64           `json:"price,omitempty"`
+	Count        int               `json:"count,omitempty"`
+	Orders       []adminOrder      `json:"orders,omitempty"`
+	Outcome      string            `json:"outcome,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// SessionRecorder appends the events to the session file. It is shared by the main loop and the strategy goroutine,
+// a nil recorder records nothing.
+type SessionRecorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	logger investgo.Logger
+}
+
+func openSessionRecorder(dir string, accountId string, logger investgo.Logger) (*SessionRecorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.session.jsonl", time.Now().Format("2006-01-02_150405"), accountId))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionRecorder{file: file, path: path, logger: logger}, nil
+}
+
+// record writes the event with the current time, every line is written at once so a crash loses nothing before it
+func (r *SessionRecorder) record(event SessionEvent) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = r.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		r.logger.Errorf("Can't record session event %v: %v", event.Kind, err.Error())
+	}
+}
+
+func (r *SessionRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// readSession returns the events of the session file
+func readSession(path string) ([]SessionEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []SessionEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var event SessionEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", path, line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// recordingBroker records every call of the broker and its response
+type recordingBroker struct {
+	broker   Broker
+	recorder *SessionRecorder
+}
+
+func newRecordingBroker(broker Broker, recorder *SessionRecorder) *recordingBroker {
+	return &recordingBroker{broker: broker, recorder: recorder}
+}
+
+// liveBrokerOf returns the liveBroker behind the broker, the recordingBroker is looked through
+func liveBrokerOf(broker Broker) (*liveBroker, bool) {
+	if recording, ok := broker.(*recordingBroker); ok {
+		broker = recording.broker
+	}
+	live, ok := broker.(*liveBroker)
+	return live, ok
+}
+
+func (b *recordingBroker) request(method string, instrumentId string, quantity int64, reason string) {
+	b.recorder.record(SessionEvent{Kind: sessionBrokerRequest, Method: method, InstrumentID: instrumentId, Quantity: quantity, Reason: reason})
+}
+
+func (b *recordingBroker) response(event SessionEvent, err error) {
+	event.Kind = sessionBrokerResponse
+	if err != nil {
+		event.Error = err.Error()
+	}
+	b.recorder.record(event)
+}
+
+func (b *recordingBroker) now() time.Time {
+	b.request("now", "", 0, "")
+	now := b.broker.now()
+	b.response(SessionEvent{Method: "now", Clock: &now}, nil)
+	return now
+}
+
+func (b *recordingBroker) lastPrice(instrumentId string) (float64, error) {
+	b.request("lastPrice", instrumentId, 0, "")
+	price, err := b.broker.lastPrice(instrumentId)
+	b.response(SessionEvent{Method: "lastPrice", Price: price}, err)
+	return price, err
+}
+
+func (b *recordingBroker) positions() ([]Position, float64, error) {
+	b.request("positions", "", 0, "")
+	positions, money, err := b.broker.positions()
+	b.response(SessionEvent{Method: "positions", Positions: positions, Money: money}, err)
+	return positions, money, err
+}
+
+func (b *recordingBroker) buy(instrumentId string, quantity int64) (OrderResult, error) {
+	b.request("buy", instrumentId, quantity, "")
+	result, err := b.broker.buy(instrumentId, quantity)
+	b.response(SessionEvent{Method: "buy", Result: &result}, err)
+	return result, err
+}
+
+func (b *recordingBroker) sell(instrumentId string, quantity int64) (OrderResult, error) {
+	b.request("sell", instrumentId, quantity, "")
+	result, err := b.broker.sell(instrumentId, quantity)
+	b.response(SessionEvent{Method: "sell", Result: &result}, err)
+	return result, err
+}
+
+func (b *recordingBroker) closePosition(instrumentId string, quantity int64) (OrderResult, error) {
+	b.request("closePosition", instrumentId, quantity, "")
+	result, err := b.broker.closePosition(instrumentId, quantity)
+	b.response(SessionEvent{Method: "closePosition", Result: &result}, err)
+	return result, err
+}
+
+func (b *recordingBroker) cancelOpenOrders(instrumentId string, reason string) (int, error) {
+	b.request("cancelOpenOrders", instrumentId, 0, reason)
+	count, err := b.broker.cancelOpenOrders(instrumentId, reason)
+	b.response(SessionEvent{Method: "cancelOpenOrders", Count: count}, err)
+	return count, err
+}
+
+func (b *recordingBroker) pendingOrders() ([]adminOrder, error) {
+	b.request("pendingOrders", "", 0, "")
+	orders, err := b.broker.pendingOrders()
+	b.response(SessionEvent{Method: "pendingOrders", Orders: orders}, err)
+	return orders, err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	"go.uber.org/zap"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// primaryPredictor is the name of the predictor the strategy trades on in the shadow comparison
+const primaryPredictor = "primary"
+
+// shadowWaitTimeout is how long the close of the session waits for the shadow predictors still answering
+const shadowWaitTimeout = 30 * time.Second
+
+// callShadowPredictor asks the shadow predictor about the candle and journals its action, nothing else depends on it
+func callShadowPredictor(name string, predictor Predictor, request RequestToPredict, instrumentId string, journal *Journal, logger investgo.Logger, wg *sync.WaitGroup) {
+	defer wg.Done()
+	response, err := predictor.predict(request)
+	if err != nil {
+		logger.Errorf("Can't get the action of shadow predictor %v: %v", name, err.Error())
+		return
+	}
+	journal.record(JournalRecord{Kind: journalShadowSignal, InstrumentID: instrumentId, ReqID: request.ReqId, Action: response.Action, Confidence: response.confidence(), ModelVersion: response.ModelVersion, Price: request.Close, Candle: &request, Message: name})
+}
+
+// waitShadowPredictors waits for the calls of the shadow predictors, so their last actions are journaled before the report.
+// It returns false if they didn't answer in the timeout, the report is written without them
+func waitShadowPredictors(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shadowResult is what the predictor would have made over the candles of the day
+type shadowResult struct {
+	Predictor string
+	Signals   int
+	// Agreement is the part of the candles with the primary action where the predictor gave the same action
+	Agreement   float64
+	StartMoney  float64
+	FinalMoney  float64
+	Trades      int
+	NetPnL      float64
+	Fees        float64
+	WinRate     float64
+	MaxDrawdown float64
+}
+
+// compareShadowPredictors runs the strategy over the signal candles of the day once for every predictor, the primary one
+// included, with the same simulated broker: the fill model, fees and risk limits of the config. A candle without the
+// action of the predictor is skipped by its run. The runs are written to dir/<predictor>.
+func compareShadowPredictors(records []JournalRecord, config Config, dir string) ([]shadowResult, error) {
+	instrumentId := journalInstrument(records)
+	candles := map[uint64]RequestToPredict{}
+	signals := map[string]map[uint64]Signal{primaryPredictor: {}}
+	money := 0.0
+	for _, record := range sortedByTime(records) {
+		switch record.Kind {
+		case journalPosition:
+			if money == 0 {
+				money = record.Money
+			}
+			continue
+		case journalSignal:
+			record.Message = primaryPredictor
+		case journalShadowSignal:
+		default:
+			continue
+		}
+		// the signals journaled before the candles were kept can't be replayed
+		if record.Candle == nil {
+			continue
+		}
+		candles[record.ReqID] = *record.Candle
+		if signals[record.Message] == nil {
+			signals[record.Message] = map[uint64]Signal{}
+		}
+		// the journals written before the confidence was journaled have none
+		confidence := record.Confidence
+		if confidence == 0 {
+			confidence = 1
+		}
+		signals[record.Message][record.ReqID] = Signal{Action: record.Action, Confidence: confidence}
+	}
+	if len(signals) == 1 {
+		return nil, errors.New("no shadow signals in the journal")
+	}
+	if money == 0 {
+		money = config.paperMoney()
+	}
+
+	ids := make([]uint64, 0, len(candles))
+	for id := range candles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return candles[ids[i]].Datetime.Before(candles[ids[j]].Datetime)
+	})
+	dayCandles := make([]RequestToPredict, len(ids))
+	for i, id := range ids {
+		dayCandles[i] = candles[id]
+	}
+
+	names := make([]string, 0, len(signals))
+	for name := range signals {
+		if name != primaryPredictor {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{primaryPredictor}, names...)
+
+	var results []shadowResult
+	for _, name := range names {
+		actions := make([]Signal, len(ids))
+		agreed, common := 0, 0
+		for i, id := range ids {
+			signal, ok := signals[name][id]
+			if !ok {
+				actions[i] = Signal{Action: -1}
+				continue
+			}
+			actions[i] = signal
+			if primary, ok := signals[primaryPredictor][id]; ok {
+				common++
+				if primary.Action == signal.Action {
+					agreed++
+				}
+			}
+		}
+		runDir := filepath.Join(dir, name)
+		err := os.MkdirAll(runDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		run, err := runBacktest(backtestRun{
+			candles:      dayCandles,
+			actions:      actions,
+			instrumentId: instrumentId,
+			money:        money,
+			config:       config,
+			fill:         config.fillModelConfig(),
+			interval:     time.Minute,
+			dir:          runDir,
+			logger:       zap.NewNop().Sugar(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		result := shadowResult{
+			Predictor:   name,
+			Signals:     len(signals[name]),
+			StartMoney:  money,
+			FinalMoney:  run.FinalMoney,
+			Trades:      run.Summary.Trades,
+			NetPnL:      run.Summary.NetPnL,
+			Fees:        run.Summary.Fees,
+			WinRate:     run.Summary.WinRate,
+			MaxDrawdown: run.Summary.MaxDrawdown,
+		}
+		if common > 0 {
+			result.Agreement = float64(agreed) / float64(common)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// writeShadowReport writes the comparison as a CSV file, the primary predictor first
+func writeShadowReport(path string, results []shadowResult) error {
+	rows := [][]string{{"predictor", "signals", "agreement", "trades", "net_pnl", "fees", "win_rate", "max_drawdown", "final_money", "return"}}
+	for _, result := range results {
+		rows = append(rows, []string{
+			result.Predictor,
+			strconv.Itoa(result.Signals),
+			fmt.Sprintf("%.4f", result.Agreement),
+			strconv.Itoa(result.Trades),
+			fmt.Sprintf("%.2f", result.NetPnL),
+			fmt.Sprintf("%.2f", result.Fees),
+			fmt.Sprintf("%.4f", result.WinRate),
+			fmt.Sprintf("%.2f", result.MaxDrawdown),
+			fmt.Sprintf("%.2f", result.FinalMoney),
+			fmt.Sprintf("%.4f", result.FinalMoney/result.StartMoney-1),
+		})
+	}
+	return writeCSV(path, rows)
+}
+
+// generateShadowReport compares the predictors over the journal of the day:
+// <reports_dir>/<date>_<account>_shadow.csv, the runs are in <reports_dir>/shadow/<date>_<account>
+func generateShadowReport(config Config, accountId string, date time.Time) (string, []shadowResult, error) {
+	records, err := readJournal(config.journalDir(), accountId, date)
+	if err != nil {
+		return "", nil, err
+	}
+	name := date.Format("2006-01-02") + "_" + accountId
+	results, err := compareShadowPredictors(records, config, filepath.Join(config.reportsDir(), "shadow", name))
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(config.reportsDir(), name+"_shadow.csv")
+	return path, results, writeShadowReport(path, results)
+}
+
+// logShadowResults logs the hypothetical profit of every predictor
+func logShadowResults(results []shadowResult, logger investgo.Logger) {
+	for _, result := range results {
+		logger.Infof("Predictor %v: signals = %v, agreement = %.2f %%, trades = %v, net profit = %.2f RUB, fees = %.2f RUB, max drawdown = %.2f RUB",
+			result.Predictor, result.Signals, result.Agreement*100, result.Trades, result.NetPnL, result.Fees, result.MaxDrawdown)
+	}
+}
+
+// runShadowReportCommand compares the primary and the shadow predictors over a past day:
+// ./TradingBot shadow-report -config <path to config file> -date 2006-01-02 [-account paper_<account id>]
+func runShadowReportCommand(args []string) {
+	flags, configFilePath := commandFlags("shadow-report")
+	dateString := flags.String("date", time.Now().Format("2006-01-02"), "day of the report, YYYY-MM-DD")
+	account := flags.String("account", "", "account of the journal, account_id of the config by default")
+	_ = flags.Parse(args)
+
+	config := readOfflineConfig(*configFilePath)
+	date := parseDate("date", *dateString)
+	if *account == "" {
+		*account = config.AccountID
+	}
+	path, results, err := generateShadowReport(config, *account, date)
+	if err != nil {
+		log.Fatalf("Cannot generate shadow report: %v", err)
+	}
+	fmt.Println("predictor\tsignals\tagreement\ttrades\tnet_pnl\tfees\twin_rate\tmax_drawdown\tfinal_money")
+	for _, result := range results {
+		fmt.Printf("%v\t%v\t%.4f\t%v\t%.2f\t%.2f\t%.4f\t%.2f\t%.2f\n", result.Predictor, result.Signals, result.Agreement,
+			result.Trades, result.NetPnL, result.Fees, result.WinRate, result.MaxDrawdown, result.FinalMoney)
+	}
+	fmt.Println(path)
+}
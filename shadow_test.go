@@ -0,0 +1,29 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitShadowPredictors(t *testing.T) {
+	var wg sync.WaitGroup
+	if !waitShadowPredictors(&wg, time.Second) {
+		t.Errorf("waitShadowPredictors without calls timed out")
+	}
+
+	release := make(chan struct{})
+	wg.Add(2)
+	go wg.Done()
+	go func() {
+		defer wg.Done()
+		<-release
+	}()
+	if waitShadowPredictors(&wg, 50*time.Millisecond) {
+		t.Errorf("waitShadowPredictors returned before the hanging call")
+	}
+	close(release)
+	if !waitShadowPredictors(&wg, time.Second) {
+		t.Errorf("waitShadowPredictors timed out after the calls finished")
+	}
+}
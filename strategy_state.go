@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/russianinvestments/invest-api-go-sdk/investgo"
+	pb "github.com/russianinvestments/invest-api-go-sdk/proto"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StrategyState is everything startStrategy needs to continue the trading day after a restart.
+// It is written to the checkpoint file after each event and restored on startup if it belongs to the same day and instrument.
+// Halted is set when the reconciliation found the drift beyond the threshold, no new orders are sent then.
+// StartMoney is the money at the start of the day, the realized profit is counted from it.
+type StrategyState struct {
+	Date              string            `json:"date"`
+	InstrumentID      string            `json:"instrument_id"`
+	CanBuy            bool              `json:"can_buy"`
+	CanSell           bool              `json:"can_sell"`
+	ShareNumber       int64             `json:"share_number"`
+	ShareNumberBefore int64             `json:"share_number_before"`
+	Halted            bool              `json:"halted"`
+	StartMoney        float64           `json:"start_money"`
+	Stats             TradingStatistics `json:"stats"`
+}
+
+// tradingStatisticsJSON mirrors TradingStatistics with exported fields so that it can be checkpointed
+type tradingStatisticsJSON struct {
+	SuccessTransactionCount int     `json:"success_transaction_count"`
+	FailedTransactionCount  int     `json:"failed_transaction_count"`
+	TransactionLength       int     `json:"transaction_length"`
+	TotalTransactionLength  int     `json:"total_transaction_length"`
+	TransactionCount        int     `json:"transaction_count"`
+	Money                   float64 `json:"money"`
+	SellPoint               float64 `json:"sell_point"`
+	BuyPoint                float64 `json:"buy_point"`
+	MaximumGain             float64 `json:"maximum_gain"`
+	MaximumProfitPercent    float64 `json:"maximum_profit_percent"`
+	MaximumLost             float64 `json:"maximum_lost"`
+	MaximumLostPercent      float64 `json:"maximum_lost_percent"`
+	MaximumMoney            float64 `json:"maximum_money"`
+	MinimumMoney            float64 `json:"minimum_money"`
+	TotalPercentProfit      float64 `json:"total_percent_profit"`
+	TotalGain               float64 `json:"total_gain"`
+	Commission              float64 `json:"commission"`
+	SoldLots                int64   `json:"sold_lots,omitempty"`
+	SoldAmount              float64 `json:"sold_amount,omitempty"`
+}
+
+func (s TradingStatistics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tradingStatisticsJSON{
+		SuccessTransactionCount: s.successTransactionCount,
+		FailedTransactionCount:  s.failedTransactionCount,
+		TransactionLength:       s.transactionLength,
+		TotalTransactionLength:  s.totalTransactionLength,
+		TransactionCount:        s.transactionCount,
+		Money:                   s.money,
+		SellPoint:               s.sellPoint,
+		BuyPoint:                s.buyPoint,
+		MaximumGain:             s.maximumGain,
+		MaximumProfitPercent:    s.maximumProfitPercent,
+		MaximumLost:             s.maximumLost,
+		MaximumLostPercent:      s.maximumLostPercent,
+		MaximumMoney:            s.maximumMoney,
+		MinimumMoney:            s.minimumMoney,
+		TotalPercentProfit:      s.totalPercentProfit,
+		TotalGain:               s.totalGain,
+		Commission:              s.commission,
+		SoldLots:                s.soldLots,
+		SoldAmount:              s.soldAmount,
+	})
+}
+
+func (s *TradingStatistics) UnmarshalJSON(data []byte) error {
+	var stats tradingStatisticsJSON
+	err := json.Unmarshal(data, &stats)
+	if err != nil {
+		return err
+	}
+	*s = TradingStatistics{
+		successTransactionCount: stats.SuccessTransactionCount,
+		failedTransactionCount:  stats.FailedTransactionCount,
+		transactionLength:       stats.TransactionLength,
+		totalTransactionLength:  stats.TotalTransactionLength,
+		transactionCount:        stats.TransactionCount,
+		money:                   stats.Money,
+		sellPoint:               stats.SellPoint,
+		buyPoint:                stats.BuyPoint,
+		maximumGain:             stats.MaximumGain,
+		maximumProfitPercent:    stats.MaximumProfitPercent,
+		maximumLost:             stats.MaximumLost,
+		maximumLostPercent:      stats.MaximumLostPercent,
+		maximumMoney:            stats.MaximumMoney,
+		minimumMoney:            stats.MinimumMoney,
+		totalPercentProfit:      stats.TotalPercentProfit,
+		totalGain:               stats.TotalGain,
+		commission:              stats.Commission,
+		soldLots:                stats.SoldLots,
+		soldAmount:              stats.SoldAmount,
+	}
+	return nil
+}
+
+// newStrategyState builds the state of a new trading day from the positions on the account, now is the broker clock
+func newStrategyState(instrumentId string, positions []Position, money float64, now time.Time) StrategyState {
+	state := StrategyState{
+		Date:         now.Format("2006-01-02"),
+		InstrumentID: instrumentId,
+		CanBuy:       true,
+		StartMoney:   money,
+		Stats: TradingStatistics{
+			money:        money,
+			maximumMoney: money,
+			minimumMoney: money,
+		},
+	}
+	//если на ночь остались акции, то по дефолту надо начинать не с покупки активов, а с их продажи! иначе отправляется запрос на покупку 0 акций, он не выполняется и бот никогда не переходит к продаже
+	if len(positions) != 0 {
+		state.CanSell = true
+		state.CanBuy = false
+		// we assume that we always work with only one stock uid
+		state.ShareNumber = positions[0].Balance
+		state.ShareNumberBefore = state.ShareNumber
+	}
+	return state
+}
+
+func checkpointFileName(dir string, accountId string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_state.json", accountId))
+}
+
+// saveStrategyState writes the checkpoint to a temporary file first, so a crash while writing doesn't destroy the previous one
+func saveStrategyState(path string, state StrategyState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadStrategyState returns the checkpointed state if it was saved on the day of now (the broker clock) for the same instrument
+func loadStrategyState(path string, instrumentId string, now time.Time) (StrategyState, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return StrategyState{}, false, nil
+	}
+	if err != nil {
+		return StrategyState{}, false, err
+	}
+	var state StrategyState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return StrategyState{}, false, err
+	}
+	if state.Date != now.Format("2006-01-02") || state.InstrumentID != instrumentId {
+		return StrategyState{}, false, nil
+	}
+	return state, true, nil
+}
+
+// reconcileRestoredState cancels orders left by the previous run and makes the restored state agree with the broker positions.
+// Broker is always right: the position and the money that differ from the checkpoint are taken from the account,
+// the blocked lots and money count as the account ones.
+func reconcileRestoredState(state *StrategyState, broker Broker, logger investgo.Logger) error {
+	cancelled, err := broker.cancelOpenOrders(state.InstrumentID, "order of the previous run")
+	if err != nil {
+		return err
+	}
+	if cancelled > 0 {
+		logger.Infof("Cancelled %v orders left by the previous run", cancelled)
+	}
+
+	positions, money, err := broker.positions()
+	if err != nil {
+		return err
+	}
+	balance := positionBalance(positions, state.InstrumentID)
+	if balance != state.ShareNumber {
+		logger.Infof("Restored position differs from the broker one: restored = %v, broker = %v. Taking the broker value", state.ShareNumber, balance)
+		state.ShareNumber = balance
+		state.ShareNumberBefore = balance
+		state.CanSell = balance > 0
+		state.CanBuy = !state.CanSell
+	}
+	if math.Abs(money-state.Stats.money) >= 0.01 {
+		logger.Infof("Restored money differs from the broker one: restored = %v, broker = %v. Taking the broker value", state.Stats.money, money)
+		state.Stats.money = money
+	}
+	return nil
+}
+
+// cancelOpenOrders cancels all active orders of the instrument on the account and returns how many were cancelled
+func cancelOpenOrders(ordersService *investgo.OrdersServiceClient, accId string, instrumentId string, reason string, journal *Journal, logger investgo.Logger) (int, error) {
+	logger.Infof("Sent GetOrders request")
+	ordersResp, err := ordersService.GetOrders(accId)
+	logger.Infof("Got response for GetOrders request")
+	if err != nil {
+		logger.Errorf("Can't get open orders: %v", err.Error())
+		return 0, err
+	}
+	cancelled := 0
+	for _, order := range ordersResp.GetOrders() {
+		if order.GetInstrumentUid() != instrumentId {
+			continue
+		}
+		logger.Infof("Cancelling open order %v: direction = %v, lotsRequested = %v, lotsExecuted = %v", order.GetOrderId(), order.GetDirection().String(), order.GetLotsRequested(), order.GetLotsExecuted())
+		cancelResp, err := ordersService.CancelOrder(accId, order.GetOrderId())
+		if err != nil {
+			logger.Errorf("Failed to cancel order: error = %v, headers = %v\n", err.Error(), investgo.MessageFromHeader(cancelResp.GetHeader()))
+			return cancelled, err
+		}
+		journal.record(JournalRecord{Kind: journalOrderState, InstrumentID: instrumentId, OrderID: order.GetOrderId(), Status: pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED.String(), LotsRequested: order.GetLotsRequested(), LotsExecuted: order.GetLotsExecuted(), Message: reason})
+		cancelled += 1
+	}
+	return cancelled, nil
+}
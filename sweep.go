@@ -0,0 +1,429 @@
+package main
+
+import (
+	"TradingBot/analytics"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SweepSpec is the file of the sweep command. Grid lists the values of the parameters, without Samples every combination
+// is run, with Samples that many sets are drawn: a value of the list for the grid parameters and a uniform value in
+// [min, max] for the Random parameters. RankBy is the metric of the ranking: sharpe (default), net_pnl, profit_factor,
+// win_rate or max_drawdown (the smaller the better).
+type SweepSpec struct {
+	Grid        map[string][]float64 `yaml:"grid"`
+	Random      map[string][]float64 `yaml:"random"`
+	Samples     int                  `yaml:"samples"`
+	Seed        int64                `yaml:"seed"`
+	RankBy      string               `yaml:"rank_by"`
+	WalkForward WalkForwardConfig    `yaml:"walk_forward"`
+}
+
+// WalkForwardConfig splits the days into windows of TrainDays followed by TestDays, the windows move by TestDays.
+// The sets are ranked by their results on the test days they were not chosen on, 0 days - the whole period is one run.
+type WalkForwardConfig struct {
+	TrainDays int `yaml:"train_days"`
+	TestDays  int `yaml:"test_days"`
+}
+
+// sweepParameters are the parameters of the config a sweep may change, the integer ones are rounded
+var sweepParameters = map[string]func(config *Config, value float64){
+	"risk.position_fraction":       func(c *Config, v float64) { c.Risk.PositionFraction = v },
+	"risk.max_daily_loss":          func(c *Config, v float64) { c.Risk.MaxDailyLoss = v },
+	"risk.max_daily_loss_percent":  func(c *Config, v float64) { c.Risk.MaxDailyLossPercent = v },
+	"risk.max_trades":              func(c *Config, v float64) { c.Risk.MaxTrades = int(math.Round(v)) },
+	"risk.max_position_lots":       func(c *Config, v float64) { c.Risk.MaxPositionLots = int64(math.Round(v)) },
+	"risk.min_confidence":          func(c *Config, v float64) { c.Risk.MinConfidence = v },
+	"fees.percent":                 func(c *Config, v float64) { c.Fees.Percent = v },
+	"fill_model.latency_ms":        func(c *Config, v float64) { c.FillModel.LatencyMs = int(math.Round(v)) },
+	"fill_model.max_participation": func(c *Config, v float64) { c.FillModel.MaxParticipation = v },
+	"fill_model.slippage_bps":      func(c *Config, v float64) { c.FillModel.SlippageBps = v },
+	"fill_model.spread_fraction":   func(c *Config, v float64) { c.FillModel.SpreadFraction = v },
+}
+
+var sweepMetrics = []string{"sharpe", "net_pnl", "profit_factor", "win_rate", "max_drawdown"}
+
+// parameterSet is one combination of the parameter values
+type parameterSet map[string]float64
+
+// apply returns the config with the values of the set
+func (p parameterSet) apply(config Config) Config {
+	for name, value := range p {
+		sweepParameters[name](&config, value)
+	}
+	return config
+}
+
+// readSweepSpec reads the sweep file and checks its parameters
+func readSweepSpec(path string) (SweepSpec, error) {
+	var spec SweepSpec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	err = yaml.Unmarshal(data, &spec)
+	if err != nil {
+		return spec, err
+	}
+	if spec.RankBy == "" {
+		spec.RankBy = "sharpe"
+	}
+	var problems []string
+	for name, values := range spec.Grid {
+		if _, ok := sweepParameters[name]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown parameter %q", name))
+		}
+		if len(values) == 0 {
+			problems = append(problems, fmt.Sprintf("no values of %v", name))
+		}
+	}
+	for name, bounds := range spec.Random {
+		if _, ok := sweepParameters[name]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown parameter %q", name))
+		}
+		if len(bounds) != 2 || bounds[0] > bounds[1] {
+			problems = append(problems, fmt.Sprintf("random %v must be [min, max]", name))
+		}
+	}
+	if len(spec.Random) > 0 && spec.Samples <= 0 {
+		problems = append(problems, "random parameters need samples")
+	}
+	if !containsString(sweepMetrics, spec.RankBy) {
+		problems = append(problems, fmt.Sprintf("unknown rank_by %q, use %v", spec.RankBy, strings.Join(sweepMetrics, ", ")))
+	}
+	if (spec.WalkForward.TrainDays > 0) != (spec.WalkForward.TestDays > 0) || spec.WalkForward.TrainDays < 0 || spec.WalkForward.TestDays < 0 {
+		problems = append(problems, "walk_forward needs both train_days and test_days")
+	}
+	if len(problems) != 0 {
+		return spec, fmt.Errorf("%v: %v", path, strings.Join(problems, "; "))
+	}
+	return spec, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parameterSets returns the sets of the spec: the whole grid or the random samples, one empty set without parameters
+func (s SweepSpec) parameterSets() []parameterSet {
+	var gridNames, randomNames []string
+	for name := range s.Grid {
+		gridNames = append(gridNames, name)
+	}
+	for name := range s.Random {
+		randomNames = append(randomNames, name)
+	}
+	sort.Strings(gridNames)
+	sort.Strings(randomNames)
+
+	if s.Samples > 0 {
+		random := rand.New(rand.NewSource(s.Seed))
+		sets := make([]parameterSet, s.Samples)
+		for i := range sets {
+			sets[i] = parameterSet{}
+			for _, name := range gridNames {
+				sets[i][name] = s.Grid[name][random.Intn(len(s.Grid[name]))]
+			}
+			for _, name := range randomNames {
+				bounds := s.Random[name]
+				sets[i][name] = bounds[0] + random.Float64()*(bounds[1]-bounds[0])
+			}
+		}
+		return sets
+	}
+	sets := []parameterSet{{}}
+	for _, name := range gridNames {
+		var next []parameterSet
+		for _, set := range sets {
+			for _, value := range s.Grid[name] {
+				combination := parameterSet{name: value}
+				for n, v := range set {
+					combination[n] = v
+				}
+				next = append(next, combination)
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+// metricValue returns the metric of the summary so that more is better
+func metricValue(summary analytics.Summary, metric string) float64 {
+	switch metric {
+	case "net_pnl":
+		return summary.NetPnL
+	case "profit_factor":
+		return summary.ProfitFactor
+	case "win_rate":
+		return summary.WinRate
+	case "max_drawdown":
+		return -summary.MaxDrawdown
+	default:
+		return summary.Sharpe
+	}
+}
+
+// aggregateSummaries joins the results of several windows: the trades, the profit and the fees are summed,
+// the ratios are averaged and the drawdown is the worst one
+func aggregateSummaries(summaries []analytics.Summary) analytics.Summary {
+	var total analytics.Summary
+	if len(summaries) == 0 {
+		return total
+	}
+	for _, summary := range summaries {
+		total.Trades += summary.Trades
+		total.NetPnL += summary.NetPnL
+		total.Fees += summary.Fees
+		total.WinRate += summary.WinRate / float64(len(summaries))
+		total.ProfitFactor += summary.ProfitFactor / float64(len(summaries))
+		total.Sharpe += summary.Sharpe / float64(len(summaries))
+		if summary.MaxDrawdown > total.MaxDrawdown {
+			total.MaxDrawdown = summary.MaxDrawdown
+		}
+	}
+	return total
+}
+
+// sweepWindow is a period of days the sets are run on, train is empty without walk-forward
+type sweepWindow struct {
+	name  string
+	train [2]int
+	test  [2]int
+}
+
+// sweepWindows returns the walk-forward windows of the days or one window of all days
+func sweepWindows(days int, walkForward WalkForwardConfig) []sweepWindow {
+	if walkForward.TrainDays == 0 {
+		return []sweepWindow{{name: "all", test: [2]int{0, days}}}
+	}
+	var windows []sweepWindow
+	for start := 0; start+walkForward.TrainDays+walkForward.TestDays <= days; start += walkForward.TestDays {
+		trainEnd := start + walkForward.TrainDays
+		windows = append(windows, sweepWindow{
+			name:  fmt.Sprintf("window-%v", len(windows)+1),
+			train: [2]int{start, trainEnd},
+			test:  [2]int{trainEnd, trainEnd + walkForward.TestDays},
+		})
+	}
+	return windows
+}
+
+// sweepJob is one backtest of the sweep, its result is stored by the index
+type sweepJob struct {
+	set    int
+	window int
+	phase  string
+	days   [2]int
+}
+
+// runSweepCommand runs the backtest for every parameter set of the spec in parallel, the actions of the predictor are
+// taken once for all runs. The ranked table is printed and written to <out>/<start time>/sweep.csv, with walk-forward
+// the chosen set of every window is written to walk_forward.csv:
+// ./TradingBot sweep -config <path to config file> -spec sweep.yaml -ticker TCSG [-from 2024-01-01] [-to 2024-06-01] [-workers 8]
+func runSweepCommand(args []string) {
+	flags, configFilePath := commandFlags("sweep")
+	f := addBacktestFlags(flags)
+	specPath := flags.String("spec", "sweep.yaml", "file with the parameter grid, the samples and the walk-forward windows")
+	workers := flags.Int("workers", runtime.NumCPU(), "backtests run at the same time")
+	_ = flags.Parse(args)
+
+	configParams := readOfflineConfig(*configFilePath)
+	spec, err := readSweepSpec(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sets := spec.parameterSets()
+	for _, set := range sets {
+		if config := set.apply(configParams); config.FillModel.MaxParticipation > 1 || config.Risk.PositionFraction > 1 {
+			log.Fatalf("Parameter set %v is out of range", set)
+		}
+	}
+	if *workers <= 0 {
+		*workers = 1
+	}
+
+	dir := filepath.Join(*f.out, time.Now().Format("2006-01-02_150405"))
+	logger := commandLogger()
+	// the requests to the predictor are logged to the file, the progress of the sweep to stderr
+	l, err := newLogger(LogConfig{Dir: dir, Level: "info", DisableStderr: true}, backtestAccount, "sweep")
+	if err != nil {
+		log.Fatalf("logger creating error %v", err)
+	}
+	predictorLogger := l.Sugar()
+	defer predictorLogger.Sync()
+	candles, actions, _, failed := prepareBacktest(f, configParams, dir, predictorLogger)
+	specData, err := yaml.Marshal(spec)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "sweep.yaml"), specData, 0644)
+	}
+	if err != nil {
+		log.Fatalf("Can't write the sweep spec: %v", err)
+	}
+
+	// the day offsets in the candles, so a window is a slice of the candles and of the actions
+	days := candleDays(candles)
+	offsets := []int{0}
+	for _, day := range days {
+		offsets = append(offsets, offsets[len(offsets)-1]+len(day))
+	}
+	windows := sweepWindows(len(days), spec.WalkForward)
+	if len(windows) == 0 {
+		log.Fatalf("%v days are less than train_days + test_days", len(days))
+	}
+	var jobs []sweepJob
+	for s := range sets {
+		for w, window := range windows {
+			if window.train[1] > 0 {
+				jobs = append(jobs, sweepJob{set: s, window: w, phase: "train", days: window.train})
+			}
+			jobs = append(jobs, sweepJob{set: s, window: w, phase: "test", days: window.test})
+		}
+	}
+	logger.Infof("Sweep: %v parameter sets, %v windows, %v backtests on %v workers, %v predictor errors", len(sets), len(windows), len(jobs), *workers, failed)
+
+	results := make([]backtestResult, len(jobs))
+	errs := make([]error, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				job := jobs[j]
+				from, to := offsets[job.days[0]], offsets[job.days[1]]
+				config := sets[job.set].apply(configParams)
+				results[j], errs[j] = runBacktest(backtestRun{
+					candles:      candles[from:to],
+					actions:      actions[from:to],
+					instrumentId: *f.instrumentId,
+					money:        *f.money,
+					config:       config,
+					fill:         config.fillModelConfig(),
+					interval:     candleDurations[*f.interval],
+					dir:          filepath.Join(dir, "runs", fmt.Sprintf("set-%v", job.set+1), windows[job.window].name+"-"+job.phase),
+					logger:       zap.NewNop().Sugar(),
+				})
+			}
+		}()
+	}
+	for j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+	for j, err := range errs {
+		if err != nil {
+			log.Fatalf("backtest of set %v failed: %v", jobs[j].set+1, err)
+		}
+	}
+
+	// the summaries by set and window
+	train := make([][]analytics.Summary, len(sets))
+	test := make([][]analytics.Summary, len(sets))
+	for s := range sets {
+		train[s] = make([]analytics.Summary, len(windows))
+		test[s] = make([]analytics.Summary, len(windows))
+	}
+	for j, job := range jobs {
+		if job.phase == "train" {
+			train[job.set][job.window] = results[j].Summary
+		} else {
+			test[job.set][job.window] = results[j].Summary
+		}
+	}
+
+	walkForward := spec.WalkForward.TrainDays > 0
+	var names []string
+	for name := range sweepParameters {
+		if _, ok := spec.Grid[name]; ok {
+			names = append(names, name)
+		} else if _, ok := spec.Random[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	order := make([]int, len(sets))
+	for i := range order {
+		order[i] = i
+	}
+	tested := make([]analytics.Summary, len(sets))
+	for s := range sets {
+		tested[s] = aggregateSummaries(test[s])
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return metricValue(tested[order[i]], spec.RankBy) > metricValue(tested[order[j]], spec.RankBy)
+	})
+
+	header := []string{"rank", "set"}
+	header = append(header, names...)
+	if walkForward {
+		header = append(header, "train_"+spec.RankBy)
+	}
+	header = append(header, "trades", "net_pnl", "fees", "win_rate", "profit_factor", "max_drawdown", "sharpe")
+	rows := [][]string{header}
+	for rank, s := range order {
+		row := []string{strconv.Itoa(rank + 1), strconv.Itoa(s + 1)}
+		for _, name := range names {
+			row = append(row, formatFloat(sets[s][name]))
+		}
+		if walkForward {
+			row = append(row, fmt.Sprintf("%.4f", metricValue(aggregateSummaries(train[s]), spec.RankBy)))
+		}
+		summary := tested[s]
+		row = append(row, strconv.Itoa(summary.Trades), fmt.Sprintf("%.2f", summary.NetPnL), fmt.Sprintf("%.2f", summary.Fees),
+			fmt.Sprintf("%.4f", summary.WinRate), fmt.Sprintf("%.4f", summary.ProfitFactor), fmt.Sprintf("%.2f", summary.MaxDrawdown), fmt.Sprintf("%.4f", summary.Sharpe))
+		rows = append(rows, row)
+	}
+	err = writeCSV(filepath.Join(dir, "sweep.csv"), rows)
+	if err != nil {
+		log.Fatalf("Can't write the sweep table: %v", err)
+	}
+	printRows(rows)
+
+	if walkForward {
+		// the set with the best train result of the window is judged by its test days
+		location := moscowLocation()
+		dayName := func(day int) string {
+			return days[day][0].Datetime.In(location).Format("2006-01-02")
+		}
+		wfRows := [][]string{{"window", "train_from", "train_to", "test_from", "test_to", "set", "train_" + spec.RankBy, "test_net_pnl", "test_sharpe"}}
+		var chosen []analytics.Summary
+		for w, window := range windows {
+			best := 0
+			for s := range sets {
+				if metricValue(train[s][w], spec.RankBy) > metricValue(train[best][w], spec.RankBy) {
+					best = s
+				}
+			}
+			chosen = append(chosen, test[best][w])
+			wfRows = append(wfRows, []string{window.name, dayName(window.train[0]), dayName(window.train[1] - 1), dayName(window.test[0]), dayName(window.test[1] - 1),
+				strconv.Itoa(best + 1), fmt.Sprintf("%.4f", metricValue(train[best][w], spec.RankBy)), fmt.Sprintf("%.2f", test[best][w].NetPnL), fmt.Sprintf("%.4f", test[best][w].Sharpe)})
+		}
+		err = writeCSV(filepath.Join(dir, "walk_forward.csv"), wfRows)
+		if err != nil {
+			log.Fatalf("Can't write the walk-forward table: %v", err)
+		}
+		total := aggregateSummaries(chosen)
+		logger.Infof("Walk-forward selection out of sample: net pnl %.2f RUB, sharpe %.4f, %v trades", total.NetPnL, total.Sharpe, total.Trades)
+	}
+	logger.Infof("Sweep results => %v", dir)
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/russianinvestments/invest-api-go-sdk/investgo"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"log"
 	"math"
 	"os"
@@ -21,73 +20,181 @@ var (
 
 // TODO: getAllPositions может просто не отвечать когда биржа перестаёт работать и я не могу закрыть позиции - тинькофф возьмёт комиссию за незакрытые позиции (250 руб за ночь)
 
-func sellOpenPositions(stats *TradingStatistics, operationsService *investgo.OperationsServiceClient, ordersService *investgo.OrdersServiceClient, accId string, logger investgo.Logger) {
+// sellOpenPositions sells everything by market and returns the positions it couldn't close
+func sellOpenPositions(stats *TradingStatistics, broker Broker, logger investgo.Logger) []Position {
 	logger.Infof("Start selling open positions before calling a day")
-	positions, money, err := getAllPositions(operationsService, accId, logger)
+	positions, money, err := broker.positions()
 	if err != nil {
 		logger.Errorf(err.Error())
 	}
+	var unclosed []Position
 	for _, pos := range positions {
-		lotsExecuted, lotsRequested, priceOrderExecuted, err := sell(ordersService, pos.Id, accId, pos.Balance, logger)
-		logger.Infof("SELL at the end of the day stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v", lotsExecuted, lotsRequested, priceOrderExecuted)
+		result, err := broker.closePosition(pos.Id, pos.Balance)
+		logger.Infof("SELL at the end of the day stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v, commission = %v", result.LotsExecuted, result.LotsRequested, result.PriceOrderExecuted, result.Commission)
 		if err != nil {
 			logger.Infof("Couldn't close position! Instrument_id = %v", pos.Id)
+			unclosed = append(unclosed, pos)
 			continue
 		}
-		calculateStatisticsAfterSell(stats, lotsExecuted, lotsRequested, priceOrderExecuted, logger)
+		calculateStatisticsAfterSell(stats, result, result.LotsExecuted >= pos.Balance, logger)
+		if result.LotsExecuted < pos.Balance {
+			unclosed = append(unclosed, Position{Balance: pos.Balance - result.LotsExecuted, Id: pos.Id})
+		}
 	}
 	logger.Infof("moneyTotal = %v", money)
+	return unclosed
 }
 
-func calculateStatisticsAfterSell(stats *TradingStatistics, lotsExecuted int64, lotsRequested int64, priceOrderExecuted float64, logger investgo.Logger) {
-	stats.money += priceOrderExecuted
-	logger.Infof("SELL stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v, moneyTotal = %v", lotsExecuted, lotsRequested, priceOrderExecuted, stats.money)
-	stats.sellPoint = priceOrderExecuted / float64(lotsExecuted)
+// calculateStatisticsAfterSell adds the money of the SELL. A partially filled SELL leaves the trade open, the rest is sold
+// by the next SELL: the trade is counted once when closed is set, with the sell point of all its parts.
+func calculateStatisticsAfterSell(stats *TradingStatistics, result OrderResult, closed bool, logger investgo.Logger) {
+	if result.LotsExecuted == 0 {
+		logger.Infof("SELL stats: nothing was executed, lotsRequested = %v", result.LotsRequested)
+		return
+	}
+	// commission is paid from the sell amount, only the rest comes to the account
+	stats.money += result.PriceOrderExecuted - result.Commission
+	stats.commission += result.Commission
+	logger.Infof("SELL stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v, commission = %v, moneyTotal = %v", result.LotsExecuted, result.LotsRequested, result.PriceOrderExecuted, result.Commission, stats.money)
+	if stats.money > stats.maximumMoney {
+		stats.maximumMoney = stats.money
+	}
+	if stats.money < stats.minimumMoney {
+		stats.minimumMoney = stats.money
+	}
+	stats.soldLots += result.LotsExecuted
+	stats.soldAmount += result.PriceOrderExecuted - result.Commission
+	if !closed {
+		logger.Infof("Trade stays open: %v lots are sold so far", stats.soldLots)
+		return
+	}
+	stats.sellPoint = stats.soldAmount / float64(stats.soldLots)
+	stats.soldLots, stats.soldAmount = 0, 0
 	gain := stats.sellPoint - stats.buyPoint
+	gainPercent := 0.0
+	// buy point is unknown for the positions left from the previous day
+	if stats.buyPoint != 0 {
+		gainPercent = gain / stats.buyPoint * 100
+	}
 	if gain > 0 {
 		stats.successTransactionCount += 1
 	} else {
 		stats.failedTransactionCount += 1
 	}
-	if gain >= stats.maximumGain {
+	// the first transaction of the day sets both extremes, otherwise a day of only losing transactions would report a zero maximum gain
+	if stats.transactionCount == 0 || gain > stats.maximumGain {
 		stats.maximumGain = gain
-		stats.maximumProfitPercent = stats.maximumGain / stats.buyPoint * 100
+		stats.maximumProfitPercent = gainPercent
 	}
-	if gain <= stats.maximumLost {
+	if stats.transactionCount == 0 || gain < stats.maximumLost {
 		stats.maximumLost = gain
-		stats.maximumLostPercent = stats.maximumLost / stats.buyPoint * 100
-	}
-	if stats.money > stats.maximumMoney {
-		stats.maximumMoney = stats.money
-	}
-	if stats.money < stats.minimumMoney {
-		stats.minimumMoney = stats.money
+		stats.maximumLostPercent = gainPercent
 	}
 	stats.transactionCount += 1
 
-	stats.totalPercentProfit = stats.totalPercentProfit + (gain / stats.buyPoint)
+	stats.totalPercentProfit = stats.totalPercentProfit + gainPercent/100
 
 	stats.totalTransactionLength = stats.totalTransactionLength + stats.transactionLength
 	stats.totalGain = stats.totalGain + gain
 	logger.Infof("Transaction took %v minutes\n", stats.transactionLength)
 }
 
-func getNewLogger(accountId string) *zap.SugaredLogger {
-	zapConfig := zap.NewDevelopmentConfig()
-	zapConfig.OutputPaths = []string{fmt.Sprintf("./logs/%s_%s_tradeStats.log", time.Now().Format("2006_January_02"), accountId), "stderr"}
-	zapConfig.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout(time.DateTime)
-	zapConfig.EncoderConfig.TimeKey = "time"
-	l, err := zapConfig.Build()
+// applyUnclosedPositions sets the strategy state after selling everything: the strategy may buy again
+// unless the position of the instrument couldn't be closed
+func applyUnclosedPositions(state *StrategyState, unclosed []Position) {
+	state.ShareNumber, state.ShareNumberBefore = 0, 0
+	state.CanSell, state.CanBuy = false, true
+	for _, pos := range unclosed {
+		if pos.Id == state.InstrumentID {
+			state.ShareNumber, state.ShareNumberBefore = pos.Balance, pos.Balance
+			state.CanSell, state.CanBuy = true, false
+		}
+	}
+}
+
+// executeAdminCommand runs the command of the admin API in the strategy goroutine, so it never overlaps with an action
+func executeAdminCommand(name string, state *StrategyState, broker Broker, logger investgo.Logger) adminReply {
+	switch name {
+	case adminResume:
+		if state.Halted {
+			logger.Infof("Admin: the halt after reconciliation is lifted")
+		}
+		state.Halted = false
+		return adminReply{Message: "resumed"}
+	case adminFlatten:
+		unclosed := sellOpenPositions(&state.Stats, broker, logger)
+		applyUnclosedPositions(state, unclosed)
+		if len(unclosed) != 0 {
+			return adminReply{Err: fmt.Errorf("%v positions couldn't be closed", len(unclosed))}
+		}
+		return adminReply{Message: "all positions are closed"}
+	case adminCancelOrders:
+		cancelled, err := broker.cancelOpenOrders(state.InstrumentID, "cancelled by admin")
+		if err != nil {
+			return adminReply{Err: err}
+		}
+		return adminReply{Message: fmt.Sprintf("%v orders cancelled", cancelled)}
+	case adminState:
+		snapshot := *state
+		orders, err := broker.pendingOrders()
+		if err != nil {
+			return adminReply{Err: err}
+		}
+		return adminReply{State: &snapshot, Orders: orders}
+	case adminSync:
+		return adminReply{}
+	}
+	return adminReply{Err: fmt.Errorf("unknown command %q", name)}
+}
+
+// getNewLogger returns the logger of the strategy, <date>_<account>_tradeStats.log
+func getNewLogger(config LogConfig, accountId string) *zap.SugaredLogger {
+	l, err := newLogger(config, accountId, "tradeStats")
 	if err != nil {
 		log.Fatalf("logger creating error %v", err)
 	}
 	return l.Sugar()
 }
 
-func startStrategy(actions chan int, client *investgo.Client, accId string, instrumentId string, wg *sync.WaitGroup) {
+// strategyParams is what one trading day of the strategy works with.
+// Reconciliation, the actual commission and the broker report are only used with a liveBroker, an empty checkpointPath turns checkpoints off
+// and an empty reportsDir the daily report. The recorder records the inputs, the broker calls and the decisions of the day,
+// initialState and reconciliations replace the checkpoint and the reconciliation with the broker by the recorded ones (replay).
+type strategyParams struct {
+	broker          Broker
+	accountId       string
+	instrumentId    string
+	journal         *Journal
+	checkpointPath  string
+	reconciliation  ReconciliationConfig
+	fees            FeeConfig
+	risk            RiskConfig
+	reportsDir      string
+	brokerReport    BrokerReportConfig
+	historyDir      string
+	benchmark       BenchmarkConfig
+	admin           *Admin
+	notifier        *Notifier
+	recorder        *SessionRecorder
+	initialState    *StrategyState
+	reconciliations <-chan StrategyState
+	// metrics get the position, cash and P&L gauges, nil in backtests
+	metrics *Metrics
+	// interval of the candles annualizes the ratios of the day, a minute if not set
+	interval time.Duration
+	logger   *zap.SugaredLogger
+}
+
+func startStrategy(actions chan Signal, params strategyParams, wg *sync.WaitGroup) {
 	defer wg.Done()
+	broker, accId, instrumentId, journal, admin, notifier, logger := params.broker, params.accountId, params.instrumentId, params.journal, params.admin, params.notifier, params.logger
+	live, isLive := liveBrokerOf(broker)
+	recorder := params.recorder
+	decide := func(action int, outcome string) {
+		admin.decision(action, outcome)
+		recorder.record(SessionEvent{Kind: sessionDecision, Action: action, Outcome: outcome})
+	}
 
-	logger := getNewLogger(accId)
 	defer func() {
 		err := logger.Sync()
 		if err != nil {
@@ -95,92 +202,235 @@ func startStrategy(actions chan int, client *investgo.Client, accId string, inst
 		}
 	}()
 
-	operationsService := client.NewOperationsServiceClient()
-	marketDataService := client.NewMarketDataServiceClient()
-	ordersService := client.NewOrdersServiceClient()
-	shareNumber, shareNumberBefore := int64(0), int64(0)
+	now := broker.now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 
-	positions, myMoney, err := getAllPositions(operationsService, accId, logger)
-	if err != nil {
-		logger.Errorf(err.Error())
-		os.Exit(-1)
+	var (
+		state    StrategyState
+		restored bool
+		err      error
+	)
+	if params.checkpointPath != "" {
+		state, restored, err = loadStrategyState(params.checkpointPath, instrumentId, now)
+		if err != nil {
+			logger.Errorf("Can't restore strategy state, starting a new day: %v", err.Error())
+		}
 	}
-
-	canSell, canBuy := false, true
-
-	//если на ночь остались акции, то по дефолту надо начинать не с покупки активов, а с их продажи! иначе отправляется запрос на покупку 0 акций, он не выполняется и бот никогда не переходит к продаже
-	if len(positions) != 0 {
-		canSell = true
-		canBuy = false
-		// we assume that we always work with only one stock uid
-		shareNumber = positions[0].Balance
-		shareNumberBefore = shareNumber
+	if params.initialState != nil {
+		state, restored = *params.initialState, true
+	}
+	// the replay starts the day from the same state
+	dayEvent := SessionEvent{Kind: sessionDayStart, InstrumentID: instrumentId}
+	if restored {
+		recorded := state
+		dayEvent.State = &recorded
+	}
+	recorder.record(dayEvent)
+	if restored {
+		logger.Infof("Restored strategy state of %v: canBuy = %v, canSell = %v, shareNumber = %v, moneyTotal = %v", state.Date, state.CanBuy, state.CanSell, state.ShareNumber, state.Stats.money)
+		// checkpoints written before the start money was stored
+		if state.StartMoney == 0 {
+			state.StartMoney = state.Stats.money
+		}
+		// no action is taken until the restored state agrees with the broker
+		err = reconcileRestoredState(&state, broker, logger)
+		if err != nil {
+			logger.Errorf(err.Error())
+			os.Exit(-1)
+		}
+	} else {
+		positions, myMoney, err := broker.positions()
+		if err != nil {
+			logger.Errorf(err.Error())
+			os.Exit(-1)
+		}
+		state = newStrategyState(instrumentId, positions, myMoney, now)
 	}
+	saveCheckpoint := func() {
+		params.metrics.setStrategyState(state)
+		if params.checkpointPath == "" {
+			return
+		}
+		err := saveStrategyState(params.checkpointPath, state)
+		if err != nil {
+			logger.Errorf("Can't save strategy state: %v", err.Error())
+		}
+	}
+	saveCheckpoint()
 	//forceSell := false
 
-	stats := TradingStatistics{
-		money:        myMoney,
-		maximumMoney: myMoney,
-		minimumMoney: myMoney,
+	stats := &state.Stats
+
+	// a simulated account can't drift, the nil channel never fires
+	var reconciliationTick <-chan time.Time
+	if isLive {
+		reconciliationTicker := time.NewTicker(time.Duration(params.reconciliation.IntervalSeconds) * time.Second)
+		defer reconciliationTicker.Stop()
+		reconciliationTick = reconciliationTicker.C
 	}
 
+	// the risk limit is notified once a day
+	riskLimitHit := false
 	logger.Infof("--------- START TRADING DAY ---------")
 	logger.Infof("Start Capital: %v", stats.money)
 	for {
-		action, ok := <-actions
+		var (
+			signal Signal
+			ok     bool
+		)
+		select {
+		case signal, ok = <-actions:
+		case <-reconciliationTick:
+			halt, err := reconcileWithBroker(&state, live.operationsService, accId, params.reconciliation, journal, logger)
+			if err == nil && halt {
+				if !state.Halted {
+					notifier.notify(eventRiskLimit, "Trading is halted: position or cash drift with the broker is beyond the limit, see the reconciliation journal")
+				}
+				state.Halted = true
+			}
+			reconciled := state
+			recorder.record(SessionEvent{Kind: sessionReconciliation, State: &reconciled})
+			saveCheckpoint()
+			continue
+		case reconciled := <-params.reconciliations:
+			state = reconciled
+			saveCheckpoint()
+			continue
+		case command := <-admin.commands:
+			recorder.record(SessionEvent{Kind: sessionAdminCommand, Command: command.name})
+			command.reply <- executeAdminCommand(command.name, &state, broker, logger)
+			saveCheckpoint()
+			continue
+		}
+		action := signal.Action
 		if !ok || action == 4 {
+			recorder.record(SessionEvent{Kind: sessionAction, Action: 4})
 			logger.Infof("Actions channel is closed, stop trading")
 			break
 		}
-		logger.Infof("Got an action: %v", action)
+		paused := admin.isPaused()
+		recorder.record(SessionEvent{Kind: sessionAction, Action: action, Confidence: signal.Confidence, Paused: paused})
+		logger.Infof("Got an action: %v, confidence = %v", action, signal.Confidence)
 		stats.transactionLength += 1
-		if action == 1 && canBuy {
+		if state.Halted && (action == 1 || action == 2) {
+			logger.Infof("Trading is halted after reconciliation, action %v is skipped", action)
+			decide(action, "skipped: halted after reconciliation")
+			saveCheckpoint()
+			continue
+		}
+		if action == 1 && state.CanBuy && paused {
+			logger.Infof("New entries are paused, action BUY is skipped")
+			decide(action, "skipped: paused")
+			saveCheckpoint()
+			continue
+		}
+		if action == 1 && state.CanBuy {
+			if limit := params.risk.entryLimit(state); limit != "" {
+				logger.Infof("Risk limit: %v, action BUY is skipped", limit)
+				if !riskLimitHit {
+					notifier.notify(eventRiskLimit, "No new entries today: %v", limit)
+					riskLimitHit = true
+				}
+				decide(action, "skipped: "+limit)
+				saveCheckpoint()
+				continue
+			}
+		}
+		if action == 1 && state.CanBuy && signal.Confidence < params.risk.MinConfidence {
+			logger.Infof("Confidence %v is below %v, action BUY is skipped", signal.Confidence, params.risk.MinConfidence)
+			decide(action, fmt.Sprintf("skipped: confidence %.2f below %v", signal.Confidence, params.risk.MinConfidence))
+			saveCheckpoint()
+			continue
+		}
+		if action == 1 && state.CanBuy {
 			logger.Infof("Got action BUY")
 			stats.transactionLength = 0
-			canSell, canBuy = true, false
+			state.CanSell, state.CanBuy = true, false
 			// TODO: что если я могу купить больше чем есть на бирже? как такое вообще отслеживать (потестить на счету с большими деньгами)
 			// надо ли пытаться купить в следующие минуты если не получилось купить всё?? (наверное надо)
-			lastPrice, err := getLastPrice(marketDataService, instrumentId, logger)
+			lastPrice, err := broker.lastPrice(instrumentId)
 			if err != nil {
-				canSell, canBuy = false, true
+				state.CanSell, state.CanBuy = false, true
 				logger.Infof("Processed action BUY")
+				decide(action, "failed: no last price")
+				saveCheckpoint()
 				continue
 			}
-			// the price may change and I won't be able to buy needed amount of stocks (that's why -1)
-			shareNumberBefore = shareNumber
-			shareNumber = int64(math.Floor(stats.money/lastPrice)) - 1
-			lotsExecuted, lotsRequested, priceOrderExecuted, err := buy(ordersService, instrumentId, accId, shareNumber, logger)
-			if err != nil {
-				canSell, canBuy = false, true
-				shareNumber = shareNumberBefore
+			state.ShareNumberBefore = state.ShareNumber
+			quantity, reason := params.risk.buyQuantity(stats.money, lastPrice, signal.Confidence)
+			if quantity == 0 {
+				state.CanSell, state.CanBuy = false, true
+				logger.Infof("Action BUY is skipped, %v: moneyTotal = %v, lastPrice = %v, confidence = %v", reason, stats.money, lastPrice, signal.Confidence)
+				decide(action, "skipped: "+reason)
+				saveCheckpoint()
+				continue
+			}
+			state.ShareNumber = quantity
+			result, err := broker.buy(instrumentId, state.ShareNumber)
+			if err != nil || result.LotsExecuted == 0 {
+				state.CanSell, state.CanBuy = false, true
+				state.ShareNumber = state.ShareNumberBefore
+				decide(action, "failed: nothing was bought")
+				saveCheckpoint()
 				continue
 			}
-			stats.money -= priceOrderExecuted
-			logger.Infof("BUY stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v, moneyTotal = %v", lotsExecuted, lotsRequested, priceOrderExecuted, stats.money)
-			shareNumber = lotsExecuted
-			shareNumberBefore = shareNumber
-			stats.buyPoint = priceOrderExecuted / float64(shareNumber)
+			stats.money -= result.PriceOrderExecuted + result.Commission
+			stats.commission += result.Commission
+			logger.Infof("BUY stats: lotsExecuted = %v, lotsRequested = %v, priceOrderExecuted = %v, commission = %v, moneyTotal = %v", result.LotsExecuted, result.LotsRequested, result.PriceOrderExecuted, result.Commission, stats.money)
+			state.ShareNumber = result.LotsExecuted
+			state.ShareNumberBefore = state.ShareNumber
+			// buy commission is a part of the price we paid for a share
+			stats.buyPoint = (result.PriceOrderExecuted + result.Commission) / float64(state.ShareNumber)
 			logger.Infof("Processed action BUY")
+			decide(action, fmt.Sprintf("bought %v lots", result.LotsExecuted))
+			notifier.notify(eventFill, "BUY %v of %v lots for %.2f RUB, commission %.2f RUB, money left %.2f RUB", result.LotsExecuted, result.LotsRequested, result.PriceOrderExecuted, result.Commission, stats.money)
 			// TODO: complete strategy with the stop-loss signals
 			//forceSell = false
-		} else if action == 2 && canSell {
+		} else if action == 2 && state.CanSell {
 			logger.Infof("Got action SELL")
 			// надо обработать случай когда не продали всё, что хотели!
-			lotsExecuted, lotsRequested, priceOrderExecuted, err := sell(ordersService, instrumentId, accId, shareNumber, logger)
-			if err != nil {
+			result, err := broker.sell(instrumentId, state.ShareNumber)
+			if err != nil && result.LotsExecuted == 0 {
 				logger.Infof("Processed action SELL")
+				decide(action, "failed: nothing was sold")
+				saveCheckpoint()
 				continue
 			}
-			canSell, canBuy = false, true
-			calculateStatisticsAfterSell(&stats, lotsExecuted, lotsRequested, priceOrderExecuted, logger)
+			// the lots that weren't sold are sold by the next SELL
+			state.ShareNumber -= result.LotsExecuted
+			state.ShareNumberBefore = state.ShareNumber
+			state.CanSell, state.CanBuy = state.ShareNumber > 0, state.ShareNumber <= 0
+			calculateStatisticsAfterSell(stats, result, state.ShareNumber <= 0, logger)
 			logger.Infof("Processed action SELL")
+			decide(action, fmt.Sprintf("sold %v lots", result.LotsExecuted))
+			notifier.notify(eventFill, "SELL %v of %v lots for %.2f RUB, commission %.2f RUB, money %.2f RUB", result.LotsExecuted, result.LotsRequested, result.PriceOrderExecuted, result.Commission, stats.money)
+		} else {
+			decide(action, fmt.Sprintf("no order: canBuy = %v, canSell = %v", state.CanBuy, state.CanSell))
 		}
+		saveCheckpoint()
 	}
 
 	logger.Infof("Closing positions at the end of the day")
-	sellOpenPositions(&stats, operationsService, ordersService, accId, logger)
+	unclosed := sellOpenPositions(stats, broker, logger)
+	applyUnclosedPositions(&state, unclosed)
+	for _, pos := range unclosed {
+		notifier.notify(eventLiquidationFailed, "Position %v of %v lots couldn't be closed at the end of the day", pos.Id, pos.Balance)
+		lastPrice, err := broker.lastPrice(pos.Id)
+		if err != nil {
+			continue
+		}
+		if fee := params.fees.overnightFee(float64(pos.Balance)*lastPrice, stats.money); fee > 0 {
+			logger.Infof("Position %v stays open over night, expected overnight fee = %v RUB", pos.Id, fee)
+			stats.money -= fee
+		}
+	}
+	if isLive {
+		applyActualCommission(stats, live.operationsService, accId, instrumentId, dayStart, journal, logger)
+	}
+	saveCheckpoint()
 
 	logger.Infof("Our System => totalMoney = %v", math.Floor(stats.money))
+	logger.Infof("Commission of the day => %v RUB", stats.commission)
 	logger.Infof("Number of transaction (BUY+SELL = 1 transaction) => %v", stats.transactionCount)
 
 	if stats.transactionCount == 0 {
@@ -188,7 +438,7 @@ func startStrategy(actions chan int, client *investgo.Client, accId string, inst
 		logger.Infof("Average percent profit per transaction => 0%")
 		logger.Infof("Average transaction length => 0#")
 	} else {
-		logger.Infof("Percent success of transaction => %v %%", (stats.successTransactionCount/stats.transactionCount)*100)
+		logger.Infof("Percent success of transaction => %v %%", float64(stats.successTransactionCount)/float64(stats.transactionCount)*100)
 		logger.Infof("Average percent profit per transaction => %v %%", stats.totalPercentProfit/float64(stats.transactionCount)*100)
 		logger.Infof("Average transaction length => %v #", stats.totalTransactionLength/stats.transactionCount)
 	}
@@ -197,15 +447,35 @@ func startStrategy(actions chan int, client *investgo.Client, accId string, inst
 	logger.Infof("Maximum loss percent in transaction => %v %%", stats.maximumLostPercent)
 	logger.Infof("Maximum capital value => %v RUB", stats.maximumMoney)
 	logger.Infof("Minimum capital value =>  %v RUB", stats.minimumMoney)
+
+	records, err := journal.records(now)
+	if err != nil {
+		logger.Errorf("Can't read the trade journal: %v", err.Error())
+	}
+	summary, _, _ := performanceFromJournal(records, instrumentId, periodsPerTradingYear(params.interval))
+	logPerformance(summary, logger)
+	notifier.notify(eventDailySummary, "Day is over: money %.2f RUB, net profit %.2f RUB, fees %.2f RUB, trades %v, win rate %.2f %%, max drawdown %.2f RUB",
+		stats.money, summary.NetPnL, summary.Fees, summary.Trades, summary.WinRate*100, summary.MaxDrawdown)
+	if params.reportsDir != "" {
+		index, err := loadIndexBenchmark(params.historyDir, params.benchmark, dayStart, dayStart.AddDate(0, 0, 1))
+		if err != nil {
+			logger.Infof("Index benchmark is skipped: %v", err.Error())
+		}
+		reportFiles, err := writeDailyReport(params.reportsDir, newDailyReport(now, accId, instrumentId, records, index, periodsPerTradingYear(params.interval)))
+		if err != nil {
+			logger.Errorf("Can't write the daily report: %v", err.Error())
+		} else {
+			logger.Infof("Daily report => %v", reportFiles)
+		}
+	}
 	logger.Infof("--------- FINISH TRADING DAY ---------\n")
 
 	// для метода GenerateBrokerReport песочница вернет []
-	// TODO:метод не выполняется, запрос висит, ответ не приходит
-	//generateReportResp, err := operationsService.GenerateBrokerReport(accId, time.Now().Add(-15*time.Hour), time.Now())
-	//if err != nil {
-	//	logger.Errorf(err.Error())
-	//}
-	//logger.Infof("\n--------- START REPORT ----------\n")
-	//logger.Infof(generateReportResp.String())
-	//logger.Infof("\n--------- END REPORT ----------\n")
+	// the report may be prepared for a long time, so it is only requested when enabled and never waited longer than the deadline
+	if isLive && params.brokerReport.EndOfDay {
+		_, err = compareDayWithBrokerReport(live.operationsService, accId, now, records, params.brokerReport, logger)
+		if err != nil {
+			logger.Errorf("Can't compare with the broker report: %v", err.Error())
+		}
+	}
 }